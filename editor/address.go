@@ -0,0 +1,342 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Addresses select a range of text, like in sam and acme. Evaluation is relative to the current dot.
+//
+//	n         line n, where 0 is the empty string at the start of the text
+//	#n        the empty string after rune n
+//	/re/      the next match of re, wrapping around at end of text
+//	?re?      the previous match of re, wrapping around at start of text
+//	.         dot
+//	$         the empty string at end of text
+//	a1+a2     a2 evaluated forwards from the end of a1, where a1 defaults to . and a2 to 1
+//	a1-a2     a2 evaluated backwards from the start of a1
+//	a1,a2     from the start of a1 to the end of a2, where a1 defaults to 0 and a2 to $
+//	a1;a2     like a1,a2 but with dot set to a1 before evaluating a2
+
+// ErrAddress is returned when an address is out of range or finds no match.
+var ErrAddress = errors.New("address out of range")
+
+// addr is a parsed address.
+type addr struct {
+	typ         byte // one of l (line), #, /, ?, ., $, +, -, , and ;
+	n           int64
+	re          *regexp.Regexp
+	left, right *addr // for +, -, , and ;
+}
+
+// rng is a range of text between two offsets.
+type rng struct {
+	q0, q1 int64
+}
+
+// text is what addresses are evaluated in, the storage of a buffer or the text an Edit command started with. Lines and runes are looked up in it, and only regular expressions need all of it.
+type text interface {
+	ReadAt(p []byte, offset int64) (int, error)
+	Bytes() []byte
+	Len() int64
+	LineStart(n int64) int64
+	LineOf(offset int64) int64
+}
+
+// textBytes is text held in a slice.
+type textBytes []byte
+
+func (t textBytes) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 || offset >= int64(len(t)) {
+		return 0, ErrAddress
+	}
+	return copy(p, t[offset:]), nil
+}
+
+func (t textBytes) Bytes() []byte {
+	return t
+}
+
+func (t textBytes) Len() int64 {
+	return int64(len(t))
+}
+
+func (t textBytes) LineStart(n int64) int64 {
+	var q int
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(t[q:], '\n')
+		if i < 0 {
+			break
+		}
+		q += i + 1
+	}
+	return int64(q)
+}
+
+func (t textBytes) LineOf(offset int64) int64 {
+	return int64(bytes.Count(t[:offset], []byte{'\n'}))
+}
+
+// Address evaluates the address expression s relative to dot and returns the resulting range. Lines and runes are looked up without reading all of the text, which only regular expressions do.
+func (b *Buffer) Address(s string) (q0, q1 int64, err error) {
+	b.initBuffer()
+
+	p := &parser{s: s}
+	a, err := p.parseAddr()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !p.eof() {
+		return 0, 0, errors.New("bad address")
+	}
+	if a == nil {
+		return b.q0, b.q1, nil
+	}
+	r, err := address(b.buf, a, rng{b.q0, b.q1}, 0)
+	return r.q0, r.q1, err
+}
+
+// parseAddr parses a compound address. It returns nil if there is none.
+func (p *parser) parseAddr() (*addr, error) {
+	left, err := p.parseSimpleAddr()
+	if err != nil {
+		return nil, err
+	}
+	if c := p.peek(); c == ',' || c == ';' {
+		p.next()
+		right, err := p.parseAddr()
+		if err != nil {
+			return nil, err
+		}
+		return &addr{typ: c, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+// parseSimpleAddr parses a sequence of primary addresses joined by + or -.
+func (p *parser) parseSimpleAddr() (*addr, error) {
+	a, err := p.parsePrimaryAddr()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.next()
+		right, err := p.parsePrimaryAddr()
+		if err != nil {
+			return nil, err
+		}
+		a = &addr{typ: c, left: a, right: right}
+	}
+	return a, nil
+}
+
+func (p *parser) parsePrimaryAddr() (*addr, error) {
+	switch c := p.peek(); {
+	case c == '#':
+		p.next()
+		return &addr{typ: '#', n: p.number(1)}, nil
+	case c >= '0' && c <= '9':
+		return &addr{typ: 'l', n: p.number(0)}, nil
+	case c == '/' || c == '?':
+		p.next()
+		re, err := p.compile(p.until(c))
+		if err != nil {
+			return nil, err
+		}
+		return &addr{typ: c, re: re}, nil
+	case c == '.' || c == '$':
+		p.next()
+		return &addr{typ: c}, nil
+	}
+	return nil, nil
+}
+
+// number reads a decimal number. It returns def if there is none.
+func (p *parser) number(def int64) int64 {
+	if c := p.peek(); c < '0' || c > '9' {
+		return def
+	}
+	var n int64
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		n = n*10 + int64(p.next()-'0')
+	}
+	return n
+}
+
+// address evaluates a in t relative to the range r. Sign is positive or negative when a is the right hand side of + or -, and 0 otherwise.
+func address(t text, a *addr, r rng, sign int) (rng, error) {
+	switch a.typ {
+	case 'l':
+		return lineaddr(t, a.n, r, sign)
+	case '#':
+		return charaddr(t, a.n, r, sign)
+	case '/':
+		if sign < 0 {
+			return prevmatch(t.Bytes(), a.re, r.q0)
+		}
+		return nextmatch(t.Bytes(), a.re, r.q1)
+	case '?':
+		if sign > 0 {
+			return nextmatch(t.Bytes(), a.re, r.q1)
+		}
+		return prevmatch(t.Bytes(), a.re, r.q0)
+	case '.':
+		return r, nil
+	case '$':
+		return rng{t.Len(), t.Len()}, nil
+	case '+', '-':
+		if a.left != nil {
+			var err error
+			if r, err = address(t, a.left, r, 0); err != nil {
+				return r, err
+			}
+		}
+		sign = 1
+		if a.typ == '-' {
+			sign = -1
+		}
+		if a.right == nil {
+			return lineaddr(t, 1, r, sign)
+		}
+		return address(t, a.right, r, sign)
+	case ',', ';':
+		r0 := rng{0, 0}
+		if a.left != nil {
+			var err error
+			if r0, err = address(t, a.left, r, 0); err != nil {
+				return r0, err
+			}
+		}
+		if a.typ == ';' {
+			r = r0
+		}
+		r1 := rng{t.Len(), t.Len()}
+		if a.right != nil {
+			var err error
+			if r1, err = address(t, a.right, r, 0); err != nil {
+				return r1, err
+			}
+		}
+		if r0.q0 > r1.q1 {
+			return r, errors.New("addresses out of order")
+		}
+		return rng{r0.q0, r1.q1}, nil
+	}
+	return r, errors.New("bad address")
+}
+
+// charaddr returns the empty range n runes from the start of t, or from r in the direction of sign.
+func charaddr(t text, n int64, r rng, sign int) (rng, error) {
+	var q int64
+	switch {
+	case sign > 0:
+		q = r.q1
+	case sign < 0:
+		q = r.q0
+	}
+	var p [utf8.UTFMax]byte
+	for ; n > 0; n-- {
+		if sign < 0 {
+			if q <= 0 {
+				return r, ErrAddress
+			}
+			k := q - utf8.UTFMax
+			if k < 0 {
+				k = 0
+			}
+			m, _ := t.ReadAt(p[:q-k], k)
+			_, size := utf8.DecodeLastRune(p[:m])
+			q -= int64(size)
+			continue
+		}
+		if q >= t.Len() {
+			return r, ErrAddress
+		}
+		m, _ := t.ReadAt(p[:], q)
+		_, size := utf8.DecodeRune(p[:m])
+		q += int64(size)
+	}
+	return rng{q, q}, nil
+}
+
+// lineaddr returns line n of t, or the n:th line from r in the direction of sign. A line includes its terminating newline.
+func lineaddr(t text, n int64, r rng, sign int) (rng, error) {
+	if sign >= 0 {
+		k := n - 1 // the line asked for
+		if sign > 0 && r.q1 > 0 {
+			// counting starts on the line r ends on, unless it ends at the start of one
+			k = t.LineOf(r.q1) + n
+			if lineStarts(t, r.q1) {
+				k--
+			}
+			if n == 0 {
+				return rng{r.q1, lineEnd(t, k)}, nil
+			}
+		} else if n == 0 {
+			return rng{0, 0}, nil
+		}
+		q0 := t.LineStart(k)
+		if t.LineOf(q0) != k { // past the last line
+			return r, ErrAddress
+		}
+		return rng{q0, lineEnd(t, k)}, nil
+	}
+
+	k := t.LineOf(r.q0)
+	if n == 0 {
+		return rng{t.LineStart(k), r.q0}, nil
+	}
+	switch k -= n; {
+	case k < -1:
+		return r, ErrAddress
+	case k == -1:
+		return rng{0, 0}, nil
+	}
+	return rng{t.LineStart(k), t.LineStart(k + 1)}, nil
+}
+
+// lineStarts returns true if a line of t starts at q, which is after a newline.
+func lineStarts(t text, q int64) bool {
+	var c [1]byte
+	t.ReadAt(c[:], q-1)
+	return c[0] == '\n'
+}
+
+// lineEnd returns the end of line k of t, after its newline if it has one.
+func lineEnd(t text, k int64) int64 {
+	if q := t.LineStart(k + 1); q > t.LineStart(k) {
+		return q
+	}
+	return t.Len() // the last line
+}
+
+// nextmatch returns the first match of re starting at or after q. An empty match at q itself is skipped. The search wraps around at end of text.
+func nextmatch(text []byte, re *regexp.Regexp, q int64) (rng, error) {
+	ms := re.FindAllIndex(text, -1)
+	for _, m := range ms {
+		if q0, q1 := int64(m[0]), int64(m[1]); q0 > q || q0 == q && q1 > q {
+			return rng{q0, q1}, nil
+		}
+	}
+	if len(ms) == 0 {
+		return rng{q, q}, errors.New("no match for regexp")
+	}
+	return rng{int64(ms[0][0]), int64(ms[0][1])}, nil
+}
+
+// prevmatch returns the last match of re ending at or before q. An empty match at q itself is skipped. The search wraps around at start of text.
+func prevmatch(text []byte, re *regexp.Regexp, q int64) (rng, error) {
+	ms := re.FindAllIndex(text, -1)
+	for i := len(ms) - 1; i >= 0; i-- {
+		if q0, q1 := int64(ms[i][0]), int64(ms[i][1]); q1 < q || q1 == q && q0 < q {
+			return rng{q0, q1}, nil
+		}
+	}
+	if len(ms) == 0 {
+		return rng{q, q}, errors.New("no match for regexp")
+	}
+	m := ms[len(ms)-1]
+	return rng{int64(m[0]), int64(m[1])}, nil
+}
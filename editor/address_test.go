@@ -0,0 +1,103 @@
+package editor_test
+
+import (
+	"testing"
+
+	"github.com/prodhe/poe/editor"
+)
+
+func TestAddress(t *testing.T) {
+	const text = "one\ntwo\nthree\nfour\n"
+
+	var tt = []struct {
+		name    string
+		q0, q1  int64
+		addr    string
+		want0   int64
+		want1   int64
+		wanterr bool
+	}{
+		{"line", 0, 0, "2", 4, 8, false},
+		{"line zero", 5, 5, "0", 0, 0, false},
+		{"last line", 0, 0, "4", 14, 19, false},
+		{"line out of range", 0, 0, "6", 0, 0, true},
+		{"char", 0, 0, "#5", 5, 5, false},
+		{"char out of range", 0, 0, "#99", 0, 0, true},
+		{"dot", 4, 6, ".", 4, 6, false},
+		{"end", 0, 0, "$", 19, 19, false},
+		{"all", 4, 6, ",", 0, 19, false},
+		{"range", 0, 0, "0,$", 0, 19, false},
+		{"line range", 0, 0, "2,3", 4, 14, false},
+		{"regexp", 0, 0, "/t.o/", 4, 7, false},
+		{"regexp wraps", 10, 10, "/one/", 0, 3, false},
+		{"regexp backwards", 19, 19, "?o?", 15, 16, false},
+		{"regexp no match", 0, 0, "/five/", 0, 0, true},
+		{"next line", 4, 8, "+", 8, 14, false},
+		{"previous line", 4, 8, "-", 0, 4, false},
+		{"relative lines", 0, 0, "1+2", 8, 14, false},
+		{"regexp plus line", 0, 0, "/two/+1", 8, 14, false},
+		{"char relative", 4, 4, ".+#2", 6, 6, false},
+		{"line start", 10, 12, ".-#0", 10, 10, false},
+		{"rest of line", 10, 10, "+0", 10, 14, false},
+		{"rest of line at its start", 8, 8, "+0", 8, 8, false},
+		{"start of line", 10, 12, "-0", 8, 10, false},
+		{"before first line", 2, 2, "-1", 0, 0, false},
+		{"before text", 2, 2, "-2", 0, 0, true},
+		{"char backwards", 10, 10, "-#3", 7, 7, false},
+		{"semicolon", 0, 0, "/two/;/o/", 4, 16, false},
+		{"out of order", 0, 0, "3,1", 0, 0, true},
+	}
+
+	for _, tc := range tt {
+		var buf editor.Buffer
+		buf.Write([]byte(text))
+		buf.SetDot(tc.q0, tc.q1)
+		q0, q1, err := buf.Address(tc.addr)
+		if (err != nil) != tc.wanterr {
+			t.Errorf("%s: %q: expected error %v, got %v", tc.name, tc.addr, tc.wanterr, err)
+			continue
+		}
+		if !tc.wanterr && (q0 != tc.want0 || q1 != tc.want1) {
+			t.Errorf("%s: %q: expected %d,%d, got %d,%d", tc.name, tc.addr, tc.want0, tc.want1, q0, q1)
+		}
+	}
+}
+
+func TestEditAddress(t *testing.T) {
+	var tt = []struct {
+		name    string
+		cmd     string
+		want    string
+		wantout string
+	}{
+		{"delete line", "2d", "one\nthree\n", ""},
+		{"change range", "1,2c/x\\n/", "x\nthree\n", ""},
+		{"whole file", ",x/e/d", "on\ntwo\nthr\n", ""},
+		{"print line", "3p", "one\ntwo\nthree\n", "three\n"},
+		{"line numbers", "/two/=", "one\ntwo\nthree\n", "/tmp/x:2\n"},
+		{"rune offsets", "2=#", "one\ntwo\nthree\n", "/tmp/x:#4,#8\n"},
+		{"relative inside loop", ",x/two/ .+1 d", "one\ntwo\n", ""},
+	}
+
+	for _, tc := range tt {
+		e := editor.New()
+		id, buf := e.NewBuffer()
+		buf.NewFile("/tmp/x")
+		buf.Write([]byte("one\ntwo\nthree\n"))
+		out := e.Edit(id, tc.cmd)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: %q: expected %q, got %q", tc.name, tc.cmd, tc.want, got)
+		}
+		if out != tc.wantout {
+			t.Errorf("%s: %q: expected output %q, got %q", tc.name, tc.cmd, tc.wantout, out)
+		}
+	}
+
+	e := editor.New()
+	id, buf := e.NewBuffer()
+	buf.Write([]byte("one\ntwo\nthree\n"))
+	e.Edit(id, "/thr/")
+	if q0, q1 := buf.Dot(); q0 != 8 || q1 != 11 {
+		t.Errorf("address only: expected dot 8,11, got %d,%d", q0, q1)
+	}
+}
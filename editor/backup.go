@@ -0,0 +1,162 @@
+package editor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// backupBase returns the name that backups of the file are named after. It is the file itself, or its mangled name in the backup directory if there is one.
+func (b *Buffer) backupBase() string {
+	if b.settings != nil && b.settings.BackupDir != "" {
+		return mangle(b.settings.BackupDir, b.Name())
+	}
+	return b.Name()
+}
+
+// backup copies the file on disk to a backup, as told by the backup policy of the settings. It is done before the file is replaced by a save, a part at a time so that a large file is not read into memory.
+func (b *Buffer) backup() error {
+	if b.settings == nil || b.settings.Backup == BackupNone {
+		return nil
+	}
+	src, err := os.Open(b.file.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // nothing to back up
+		}
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if b.settings.BackupDir != "" {
+		if err := os.MkdirAll(b.settings.BackupDir, 0700); err != nil {
+			return err
+		}
+	}
+
+	base := b.backupBase()
+	var ns []int
+	var name string
+	switch b.settings.Backup {
+	case BackupSimple:
+		name = base + "~"
+	case BackupNumbered:
+		if ns, err = numberedBackups(base); err != nil {
+			return err
+		}
+		n := 1
+		if len(ns) > 0 {
+			n = ns[len(ns)-1] + 1
+		}
+		name = fmt.Sprintf("%s.~%d~", base, n)
+	default:
+		return fmt.Errorf("unknown backup policy: %s", b.settings.Backup)
+	}
+
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// remove the oldest numbered backups beyond those to keep
+	if keep := b.settings.Backups; keep > 0 && len(ns)+1 > keep {
+		for _, n := range ns[:len(ns)+1-keep] {
+			os.Remove(fmt.Sprintf("%s.~%d~", base, n))
+		}
+	}
+	return nil
+}
+
+// numberedBackups returns the numbers of the existing numbered backups named after base, in increasing order.
+func numberedBackups(base string) ([]int, error) {
+	dir, file := filepath.Split(base)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prefix := file + ".~"
+	var ns []int
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "~") || len(name) < len(prefix)+2 {
+			continue
+		}
+		if n, err := strconv.Atoi(name[len(prefix) : len(name)-1]); err == nil && n > 0 {
+			ns = append(ns, n)
+		}
+	}
+	sort.Ints(ns)
+	return ns, nil
+}
+
+// Backups returns the names of the existing backups of the file, newest first. Both simple and numbered backups are listed, regardless of the current policy.
+func (b *Buffer) Backups() ([]string, error) {
+	if b.file == nil || b.file.name == "" {
+		return nil, errors.New("no filename")
+	}
+	base := b.backupBase()
+	ns, err := numberedBackups(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i := len(ns) - 1; i >= 0; i-- {
+		names = append(names, fmt.Sprintf("%s.~%d~", base, ns[i]))
+	}
+	if info, err := os.Stat(base + "~"); err == nil {
+		// put the simple backup among the numbered ones by age
+		i := 0
+		for i < len(names) {
+			if ni, err := os.Stat(names[i]); err == nil && ni.ModTime().Before(info.ModTime()) {
+				break
+			}
+			i++
+		}
+		names = append(names[:i], append([]string{base + "~"}, names[i:]...)...)
+	}
+	return names, nil
+}
+
+// RestoreBackup replaces the text of the buffer with the content of the backup fn. The buffer is left as changed, so that saving it brings the backup back.
+func (b *Buffer) RestoreBackup(fn string) error {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	if b.file != nil && !b.Paged() { // a paged file is kept byte for byte
+		b.file.detect(data, b.readEncoding())
+		data = b.file.decode(data)
+	}
+	if _, err := b.Replace(0, b.Len(), data); err != nil {
+		return err
+	}
+	b.SetDot(0, 0)
+	return nil
+}
@@ -1,12 +1,15 @@
 package editor
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -19,19 +22,25 @@ const (
 	BufferDir
 )
 
-// Buffer is a buffer for editing. It uses an underlying gap buffer for storage and manages all things text related, like insert, delete, selection, searching and undo/redo.
+// Buffer is a buffer for editing. It uses an underlying gap buffer for storage, or a piece table for large files, and manages all things text related, like insert, delete, selection, searching and undo/redo.
 //
-// Although the underlying buffer is a pure byte slice, Buffer only works with runes and UTF-8.
+// Although the underlying buffer is a pure byte slice, Buffer only works with runes and UTF-8. Files in other encodings are converted when read and written.
 type Buffer struct {
-	buf      *gapbuffer.Buffer
+	buf      storage
 	file     *file
 	what     uint8
 	dirty    bool
-	q0, q1   int     // dot/cursor
-	off      int     // offset for reading runes in buffer
+	q0, q1   int64   // dot/cursor
+	off      int64   // offset for reading runes in buffer
 	lastRune rune    // save the last read rune
 	runeBuf  []byte  // temp buf to read rune at a time from gap buffer
 	history  History // undo/redo stack
+	dir      string  // working directory if there is no file
+	settings *Settings
+	swap     bool      // a recovery file has been written or recovered
+	swapped  time.Time // history time of the text in the recovery file
+	stale    bool      // the file has changed on disk while there were unsaved changes
+	marks    []*int64  // offsets that follow their text, see Mark
 }
 
 // initBuffer initialized a nil buffer into the zero value of buffer.
@@ -54,59 +63,298 @@ func (b *Buffer) ReadFile() error {
 		return nil // silent
 	}
 
-	info, err := os.Stat(b.file.name)
+	p, err := b.loadPaged()
 	if err != nil {
-		// if the file exists, print why we could not open it
-		// otherwise just close silently
-		if os.IsExist(err) {
-			return fmt.Errorf("%s", err)
-		}
 		return err
 	}
+	if p != nil {
+		b.buf.Destroy()
+		b.buf = p
+		return nil // the undo journal is only kept for files that are hashed
+	}
+
+	text, err := b.load()
+	if err != nil {
+		return err
+	}
+	if _, err := b.buf.Write(text); err != nil {
+		return err
+	}
+
+	if b.what == BufferDir {
+		return nil
+	}
+	return b.loadHistory()
+}
+
+// load reads the file of the buffer, or lists its content if it is a directory, and returns the text. The file information is updated to match it.
+func (b *Buffer) load() ([]byte, error) {
+	info, err := os.Stat(b.file.name)
+	if os.IsNotExist(err) {
+		// a new file, which is created when saved
+		b.what = BufferFile
+		b.file.new = true
+		b.file.readonly = false
+		b.file.encoding = b.readEncoding()
+		b.file.crlf = false
+		b.file.bom = false
+		b.file.binary = false
+		b.file.mtime = time.Time{}
+		b.file.sha256 = ""
+		b.file.read = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
 
 	// name is a directory; list it's content into the buffer
 	if info.IsDir() {
 		files, err := ioutil.ReadDir(b.file.name)
 		if err != nil {
-			return fmt.Errorf("%s", err)
+			return nil, fmt.Errorf("%s", err)
 		}
 
-		b.what = BufferDir
-
 		// list files in dir
+		var text bytes.Buffer
 		for _, f := range files {
 			dirchar := ""
 			if f.IsDir() {
 				dirchar = string(filepath.Separator)
 			}
-			fmt.Fprintf(b.buf, "%s%s\n", f.Name(), dirchar)
+			fmt.Fprintf(&text, "%s%s\n", f.Name(), dirchar)
 		}
-		return nil
+
+		b.what = BufferDir
+		b.file.mtime = info.ModTime()
+		b.file.read = true
+		return text.Bytes(), nil
 	}
 
-	// name is a file
-	fh, err := os.OpenFile(b.file.name, os.O_RDWR|os.O_CREATE, 0644)
+	// name is a file, which is only read here
+	fh, err := os.Open(b.file.name)
 	if err != nil {
-		return fmt.Errorf("%s", err)
+		return nil, fmt.Errorf("%s", err)
 	}
 	defer fh.Close()
 
-	if _, err := io.Copy(b.buf, fh); err != nil {
-		return fmt.Errorf("%s", err)
+	text, err := ioutil.ReadAll(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
 	}
-	fh.Seek(0, 0)
-
-	h := sha256.New()
-	if _, err := io.Copy(h, fh); err != nil {
-		return fmt.Errorf("%s", err)
-	}
-	b.file.sha256 = fmt.Sprintf("%x", h.Sum(nil))
 
+	b.file.sha256 = fmt.Sprintf("%x", sha256.Sum256(text))
 	b.file.mtime = info.ModTime()
 	b.file.read = true
+	b.file.new = false
+	b.file.detect(text, b.readEncoding())
+	b.file.readonly = !writable(b.file.name) || b.file.binary // a keystroke in a binary file is more likely a mistake
 
 	b.what = BufferFile
 
+	return b.file.decode(text), nil
+}
+
+// readEncoding returns the encoding to read files in according to the settings, or an empty string to guess it.
+func (b *Buffer) readEncoding() string {
+	if b.settings == nil {
+		return ""
+	}
+	return b.settings.Encoding
+}
+
+// Reload reads the file or directory of the buffer again, throwing away any unsaved changes. Only the lines that differ are changed, so that the dot and marks stay with their text, and the whole reload is undone as one.
+func (b *Buffer) Reload() error {
+	b.initBuffer()
+
+	if b.file == nil || b.file.name == "" {
+		return errors.New("no filename")
+	}
+	if b.Paged() {
+		return b.reopen()
+	}
+	text, err := b.load()
+	if err != nil {
+		return err
+	}
+	if b.file.new {
+		return fmt.Errorf("%s: no such file", b.file.name)
+	}
+
+	old, lines := splitLines(b.buf.Bytes()), splitLines(text)
+	off := make([]int64, len(old)+1) // offset of each line in the buffer
+	for i, l := range old {
+		off[i+1] = off[i] + int64(len(l))
+	}
+
+	// the dot and marks follow the text after them, even where lines are replaced
+	q0, q1 := b.Dot()
+	follow := append([]*int64{&q0, &q1}, b.marks...)
+	pos := make([]int64, len(follow))
+	for i, p := range follow {
+		pos[i] = *p
+	}
+
+	b.history.Begin()
+	hs := diffLines(old, lines)
+	for i := len(hs) - 1; i >= 0; i-- {
+		h := hs[i]
+		q, n := off[h.a0], off[h.a1]-off[h.a0]
+		if n > 0 {
+			b.do(Change{offset: q, action: HDelete, content: []byte(strings.Join(old[h.a0:h.a1], ""))})
+		}
+		ins := []byte(strings.Join(lines[h.b0:h.b1], ""))
+		if len(ins) > 0 {
+			b.do(Change{offset: q, action: HInsert, content: ins})
+		}
+		for k := range pos {
+			switch {
+			case pos[k] < q:
+			case pos[k] < q+n:
+				pos[k] = q
+			default:
+				pos[k] += int64(len(ins)) - n
+			}
+		}
+	}
+	b.history.End()
+
+	for i, p := range follow {
+		*p = pos[i]
+	}
+	b.SetDot(q0, q1)
+
+	b.dirty = false
+	b.stale = false
+	return nil
+}
+
+// Poll checks if the file or directory of the buffer has changed on disk since it was last read or written. A changed directory is listed again, and so is a changed file if the buffer has no unsaved changes. Otherwise the buffer is marked as stale, and Poll returns true. A stale buffer is not checked again until it is saved or reloaded.
+func (b *Buffer) Poll() (bool, error) {
+	if b.file == nil || !b.file.read || b.stale {
+		return false, nil
+	}
+	info, err := os.Stat(b.file.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // removed, which is noticed when saving
+		}
+		return false, err
+	}
+	if info.ModTime().Equal(b.file.mtime) {
+		return false, nil
+	}
+
+	if b.what == BufferDir {
+		return false, b.Reload()
+	}
+	modified, err := b.Modified()
+	if err != nil {
+		return false, err
+	}
+	if !modified {
+		b.file.mtime = info.ModTime() // only touched
+		return false, nil
+	}
+	if !b.dirty {
+		return false, b.Reload()
+	}
+	b.stale = true
+	return true, nil
+}
+
+// Stale returns true if the file has changed on disk while the buffer had unsaved changes.
+func (b *Buffer) Stale() bool {
+	return b.stale
+}
+
+// IsNew returns true if the file of the buffer did not exist when it was read, and has not been saved since.
+func (b *Buffer) IsNew() bool {
+	return b.file != nil && b.file.new
+}
+
+// ReadOnly returns true if the file of the buffer could not be written to when it was read, or if it is binary. Such a buffer may be changed, but not saved to its file.
+func (b *Buffer) ReadOnly() bool {
+	return b.file != nil && b.file.readonly
+}
+
+// SetReadOnly sets whether the buffer may be saved to its file, for example to save changes to a binary file.
+func (b *Buffer) SetReadOnly(ro bool) error {
+	if b.file == nil || b.what != BufferFile {
+		return errors.New("no file")
+	}
+	b.file.readonly = ro
+	return nil
+}
+
+// Binary returns true if the file of the buffer looked like binary data rather than text when it was read. Its bytes are kept as they are, and the dot may be at any byte, not only at the start of a rune.
+func (b *Buffer) Binary() bool {
+	return b.file != nil && b.file.binary
+}
+
+// CRLF returns true if the lines of the file of the buffer end with \r\n on disk. They always end with \n in the buffer.
+func (b *Buffer) CRLF() bool {
+	return b.file != nil && b.file.crlf
+}
+
+// SetCRLF sets whether the lines of the file end with \r\n or \n when it is saved. The buffer counts as changed if the line endings do.
+func (b *Buffer) SetCRLF(crlf bool) error {
+	if b.file == nil || b.what != BufferFile {
+		return errors.New("no file")
+	}
+	if b.file.binary {
+		return errors.New("binary file has no line endings")
+	}
+	if b.Paged() {
+		return errors.New("cannot change the line endings of a paged file")
+	}
+	if b.file.crlf != crlf {
+		b.file.crlf = crlf
+		b.dirty = true
+	}
+	return nil
+}
+
+// BOM returns true if the file of the buffer starts with the byte order mark of its encoding. It is kept out of the buffer, and written back when saving.
+func (b *Buffer) BOM() bool {
+	return b.file != nil && b.file.bom
+}
+
+// Encoding returns the character encoding of the file of the buffer, one of the Encoding constants.
+func (b *Buffer) Encoding() string {
+	if b.file == nil || b.file.encoding == "" {
+		return EncodingUTF8
+	}
+	return b.file.encoding
+}
+
+// SetEncoding sets the character encoding that the file is written in when it is saved, and fails if the text has characters that the encoding does not. The byte order mark is kept if the new encoding has one. The buffer counts as changed if the encoding does.
+func (b *Buffer) SetEncoding(name string) error {
+	b.initBuffer()
+	if b.file == nil || b.what != BufferFile {
+		return errors.New("no file")
+	}
+	if b.file.binary {
+		return errors.New("binary file has no encoding")
+	}
+	if b.Paged() {
+		return errors.New("cannot change the encoding of a paged file")
+	}
+	name, err := LookupEncoding(name)
+	if err != nil {
+		return err
+	}
+	if name == b.Encoding() {
+		return nil
+	}
+	f := *b.file
+	f.encoding = name
+	f.bom = f.bom && len(f.enc().bom) > 0
+	if _, err := f.encode(b.buf.Bytes()); err != nil {
+		return err
+	}
+	b.file.encoding, b.file.bom = f.encoding, f.bom
+	b.dirty = true
 	return nil
 }
 
@@ -115,8 +363,115 @@ func (b *Buffer) IsDir() bool {
 	return b.what == BufferDir
 }
 
-// SaveFile writes content of buffer to its filename.
-func (b *Buffer) SaveFile() (int, error) {
+// SaveFile writes content of buffer to its filename. If the file has been changed on disk since it was last read or written, it is left alone and ErrModified is returned.
+func (b *Buffer) SaveFile() (int64, error) {
+	return b.save(false)
+}
+
+// OverwriteFile writes content of buffer to its filename like SaveFile, even if the file has been changed on disk.
+func (b *Buffer) OverwriteFile() (int64, error) {
+	return b.save(true)
+}
+
+// SaveAs writes content of buffer to the new file fn, which must not already exist, and makes it the file of the buffer. The old file is left as it is.
+func (b *Buffer) SaveAs(fn string) (int64, error) {
+	b.initBuffer()
+
+	if b.what == BufferDir {
+		return 0, errors.New("cannot save a directory listing")
+	}
+
+	// the new file keeps the encoding, line endings and byte order mark of the old one, and is binary if it was
+	nf := &file{name: fn, read: true}
+	if b.file != nil {
+		nf.encoding, nf.crlf, nf.bom, nf.binary = b.file.encoding, b.file.crlf, b.file.bom, b.file.binary
+	}
+	r, err := b.encoded(nf)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return 0, fmt.Errorf("%s already exists", fn)
+		}
+		return 0, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(fn)
+	}
+	if err != nil {
+		os.Remove(fn)
+		return 0, err
+	}
+
+	b.RemoveSwap() // named after the old file
+	nf.mtime = info.ModTime()
+	nf.sha256 = fmt.Sprintf("%x", h.Sum(nil))
+	b.file = nf
+	b.dirty = false
+	b.stale = false
+	b.repage()
+
+	return n, b.SaveHistory()
+}
+
+// Modified returns true if the file on disk has changed since the buffer last read or wrote it. The checksum is only compared if the modification time differs.
+func (b *Buffer) Modified() (bool, error) {
+	if b.file == nil || !b.file.read || b.what != BufferFile {
+		return false, nil
+	}
+	info, err := os.Stat(b.file.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // removed, so it is ours to write again
+		}
+		return false, err
+	}
+	if info.ModTime().Equal(b.file.mtime) {
+		return false, nil
+	}
+	if b.file.sha256 == "" {
+		return true, nil // not hashed, like a paged file
+	}
+	f, err := os.Open(b.file.name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, errors.Wrap(err, "sha256")
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)) != b.file.sha256, nil
+}
+
+// DiffFile returns the differences between the file on disk and the buffer as a unified diff. It is empty if there are none.
+func (b *Buffer) DiffFile() (string, error) {
+	b.initBuffer()
+
+	if b.file == nil || b.file.name == "" {
+		return "", errors.New("no filename")
+	}
+	disk, err := ioutil.ReadFile(b.file.name)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	a, c := splitLines(b.file.decode(disk)), splitLines(b.buf.Bytes())
+	return unified(b.file.name, b.file.name+" (poe)", a, c, diffLines(a, c)), nil
+}
+
+func (b *Buffer) save(force bool) (int64, error) {
 	b.initBuffer()
 
 	if b.file == nil || b.file.name == "" {
@@ -127,52 +482,52 @@ func (b *Buffer) SaveFile() (int, error) {
 		return 0, nil
 	}
 
-	// check for file existence if we recently changed the file name
-	//	openmasks := os.O_RDWR | os.O_CREATE
-	//	var namechange bool
-	//	if win.Name() != win.NameTag() { // user has changed name
-	//		openmasks |= os.O_EXCL // must not already exist
-	//		namechange = true      // to skip sha256 checksum
-	//	}
+	if b.file.readonly {
+		return 0, ErrReadOnly
+	}
 
-	f, err := os.OpenFile(b.file.name, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		if os.IsExist(err) {
-			return 0, fmt.Errorf("%s already exists", b.file.name)
+	if !b.file.read {
+		if _, err := os.Stat(b.file.name); err == nil {
+			return 0, ErrNotRead
 		}
-		return 0, err
 	}
-	defer f.Close()
 
-	//h := sha256.New()
-	//if _, err := io.Copy(h, f); err != nil {
-	//	return 0, errors.Wrap(err, "sha256")
-	//}
-	//hhex := fmt.Sprintf("%x", h.Sum(nil))
+	if !force {
+		modified, err := b.Modified()
+		if err != nil {
+			return 0, err
+		}
+		if modified {
+			return 0, ErrModified
+		}
+	}
 
-	// verify checksum if the file is not newly created via a namechange
-	//	if !namechange && hhex != win.file.sha256 {
-	//		return 0, errors.Errorf("file has been modified outside of poe")
-	//	}
+	if err := b.backup(); err != nil {
+		return 0, errors.Wrap(err, "backup")
+	}
 
-	n, err := f.WriteAt(b.buf.Bytes(), 0)
+	r, err := b.encoded(b.file)
 	if err != nil {
 		return 0, err
 	}
-	f.Truncate(int64(n))
-	f.Sync()
-
-	b.file.sha256 = fmt.Sprintf("%x", sha256.Sum256(b.buf.Bytes()))
-
-	info, err := f.Stat()
+	h := sha256.New()
+	info, err := writeFile(b.file.name, io.TeeReader(r, h))
 	if err != nil {
-		return n, err
+		return 0, err
 	}
+	n := info.Size()
+
+	b.file.sha256 = fmt.Sprintf("%x", h.Sum(nil))
 	b.file.mtime = info.ModTime()
+	b.file.read = true
+	b.file.new = false
 
 	b.dirty = false
+	b.stale = false
+	b.RemoveSwap()
+	b.repage()
 
-	return n, nil
+	return n, b.SaveHistory()
 }
 
 // Name returns either the file from disk name or empty string if the buffer has no disk counterpart.
@@ -184,8 +539,11 @@ func (b *Buffer) Name() string {
 	return s
 }
 
-// WorkDir returns the working directory of the underlying file, ie the absolute path to the file with the last part stripped. If file is a directory, its name is returned as is.
+// WorkDir returns the working directory of the underlying file, ie the absolute path to the file with the last part stripped. If file is a directory, its name is returned as is. A buffer without a file returns the directory it was created in, if any.
 func (b *Buffer) WorkDir() string {
+	if b.file == nil || b.file.name == "" {
+		return b.dir
+	}
 	switch b.what {
 	case BufferFile:
 		return filepath.Dir(b.Name())
@@ -198,30 +556,40 @@ func (b *Buffer) WorkDir() string {
 
 // Write implements io.Writer, with the side effect of storing written data into a history stack for undo/redo.
 //
-// If dot has content, it will be replaced by an initial deletion before inserting the bytes.
+// If dot has content, it will be replaced by an initial deletion before inserting the bytes. The replacement is undone as one. A single rune written to an empty dot is considered typing, and consecutive typing on the same line is undone as one.
 func (b *Buffer) Write(p []byte) (int, error) {
 	b.initBuffer()
 
+	typing := b.q0 == b.q1 && utf8.RuneCount(p) == 1
+	if !typing {
+		b.history.Begin()
+		defer b.history.End()
+	}
+
 	// handle replace
-	if len(b.ReadDot()) > 0 {
-		b.Delete()
+	if dot := b.ReadDot(); len(dot) > 0 {
+		if _, err := b.do(Change{offset: b.q0, action: HDelete, content: []byte(dot)}); err != nil {
+			return 0, err
+		}
 	}
 
 	// do the actual insertion
-	c := Change{b.q0, HInsert, p}
-	n, err := b.commit(c)
+	c := Change{offset: b.q0, action: HInsert, content: p}
+	var n int
+	var err error
+	if typing {
+		n, err = b.typed(c)
+	} else {
+		n, err = b.do(c)
+	}
 	if err != nil {
 		return n, err
 	}
-	b.history.Do(c)
-	b.SeekDot(n, 1) // move dot
-	if b.what == BufferFile {
-		b.dirty = true
-	}
+	b.SeekDot(int64(n), 1) // move dot
 	return n, nil
 }
 
-// Delete removes current selection in dot. If dot is empty, it selects the previous rune and deletes that.
+// Delete removes current selection in dot, leaving an empty dot. If dot is empty, it selects the previous rune and deletes that. Consecutive deletions of single runes are undone as one.
 func (b *Buffer) Delete() (int, error) {
 	b.initBuffer()
 
@@ -233,38 +601,113 @@ func (b *Buffer) Delete() (int, error) {
 			c, _ = b.buf.ByteAt(b.q0)
 		}
 		if b.q0 < 0 {
+			b.q0 = 0
 			return 0, nil
 		}
+		defer b.SetDot(b.q0, b.q0)
+		return b.typed(Change{offset: b.q0, action: HDelete, content: []byte(b.ReadDot())})
 	}
-	c := Change{b.q0, HDelete, []byte(b.ReadDot())}
-	n, err := b.commit(c)
-	if err != nil {
-		return n, err
+	defer b.SetDot(b.q0, b.q0)
+	return b.do(Change{offset: b.q0, action: HDelete, content: []byte(b.ReadDot())})
+}
+
+// Replace replaces the text between offsets q0 and q1 with p and selects the inserted text. It is stored as a single change set in history.
+func (b *Buffer) Replace(q0, q1 int64, p []byte) (int, error) {
+	b.initBuffer()
+
+	b.history.Begin()
+	defer b.history.End()
+
+	q0, q1, _ = b.SetDot(q0, q1)
+	if q1 > q0 {
+		if _, err := b.do(Change{offset: q0, action: HDelete, content: []byte(b.ReadDot())}); err != nil {
+			return 0, err
+		}
 	}
-	b.history.Do(c)
-	if b.what == BufferFile {
-		b.dirty = true
+	var n int
+	if len(p) > 0 {
+		var err error
+		if n, err = b.do(Change{offset: q0, action: HInsert, content: p}); err != nil {
+			return 0, err
+		}
 	}
+	b.SetDot(q0, q0+int64(n))
 	return n, nil
 }
 
 // Destroy will mark the buffer as completely empty and reset to 0.
 func (b *Buffer) Destroy() {
+	b.initBuffer()
 	b.buf.Destroy()
 	b.SetDot(0, 0)
+	for _, m := range b.marks {
+		*m = 0
+	}
+	b.history = History{}
 	b.dirty = false
 	if b.file != nil {
 		b.file.read = false
 	}
 }
 
+// Mark makes the offset p points to follow its text, so that it moves along when text is inserted or deleted before it. It is meant for positions kept outside of the buffer, like the scroll position of a view.
+func (b *Buffer) Mark(p *int64) {
+	b.marks = append(b.marks, p)
+}
+
+// Unmark stops the offset p points to from following its text.
+func (b *Buffer) Unmark(p *int64) {
+	for i, m := range b.marks {
+		if m == p {
+			b.marks = append(b.marks[:i], b.marks[i+1:]...)
+			return
+		}
+	}
+}
+
 // Len returns the number of bytes in buffer.
-func (b *Buffer) Len() int {
+func (b *Buffer) Len() int64 {
 	b.initBuffer()
 
 	return b.buf.Len()
 }
 
+// LineCount returns the number of lines in buffer, which is one more than the number of newlines.
+func (b *Buffer) LineCount() int64 {
+	b.initBuffer()
+
+	return b.buf.LineCount()
+}
+
+// LineStart returns the offset of the start of line n, counting from 0. Lines before the first or after the last are taken to be the first or the last.
+func (b *Buffer) LineStart(n int64) int64 {
+	b.initBuffer()
+
+	return b.buf.LineStart(n)
+}
+
+// LineOf returns the line that offset is on, counting from 0.
+func (b *Buffer) LineOf(offset int64) int64 {
+	b.initBuffer()
+
+	return b.buf.LineOf(offset)
+}
+
+// OffsetToLineCol returns the line that offset is on and the number of runes before it on that line, both counting from 0.
+func (b *Buffer) OffsetToLineCol(offset int64) (line, col int64) {
+	b.initBuffer()
+
+	line = b.buf.LineOf(offset)
+	for q := b.buf.LineStart(line); q < offset; col++ {
+		_, n, err := b.ReadRuneAt(q)
+		if err != nil {
+			break
+		}
+		q += int64(n)
+	}
+	return line, col
+}
+
 // String returns the entire text buffer as a string.
 func (b *Buffer) String() string {
 	b.initBuffer()
@@ -280,7 +723,7 @@ func (b *Buffer) Dirty() bool {
 // ReadRune reads a rune from buffer and advances the internal offset. This could be called in sequence to get all runes from buffer. This populates LastRune().
 func (b *Buffer) ReadRune() (r rune, size int, err error) {
 	r, size, err = b.ReadRuneAt(b.off)
-	b.off += size
+	b.off += int64(size)
 	b.lastRune = r
 	return
 }
@@ -293,14 +736,14 @@ func (b *Buffer) UnreadRune() (r rune, size int, err error) {
 	if err != nil {
 		return
 	}
-	b.off -= size
+	b.off -= int64(size)
 	return
 }
 
 // ReadRuneAt returns the rune and its size at offset. If the given offset (in byte count) is not a valid rune, it will try to back up until it finds a valid starting point for a rune and return that one.
 //
 // This is basically a Seek(offset) followed by a ReadRune(), but does not affect the internal offset for future reads.
-func (b *Buffer) ReadRuneAt(offset int) (r rune, size int, err error) {
+func (b *Buffer) ReadRuneAt(offset int64) (r rune, size int, err error) {
 	b.initBuffer()
 
 	var c byte
@@ -332,6 +775,13 @@ func (b *Buffer) ReadRuneAt(offset int) (r rune, size int, err error) {
 	return r, n, nil
 }
 
+// ByteAt returns the byte at offset.
+func (b *Buffer) ByteAt(offset int64) (byte, error) {
+	b.initBuffer()
+
+	return b.buf.ByteAt(offset)
+}
+
 // LastRune returns the last rune read by ReadRune().
 func (b *Buffer) LastRune() rune {
 	return b.lastRune
@@ -353,12 +803,12 @@ func (b *Buffer) ReadDot() string {
 }
 
 // Dot returns current offsets for dot.
-func (b *Buffer) Dot() (int, int) {
+func (b *Buffer) Dot() (int64, int64) {
 	return b.q0, b.q1
 }
 
 // Seek implements io.Seeker and sets the internal offset for next ReadRune() or UnreadRune(). If the offset is not a valid rune start, it will backup until it finds one.
-func (b *Buffer) Seek(offset, whence int) (int, error) {
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
 	b.initBuffer()
 
 	b.off = offset
@@ -384,7 +834,7 @@ func (b *Buffer) Seek(offset, whence int) (int, error) {
 }
 
 // SeekDot sets the dot to a single offset in the text buffer.
-func (b *Buffer) SeekDot(offset, whence int) (int, error) {
+func (b *Buffer) SeekDot(offset int64, whence int) (int64, error) {
 	switch whence {
 	case io.SeekStart:
 		q0, _, err := b.SetDot(offset, offset)
@@ -400,8 +850,8 @@ func (b *Buffer) SeekDot(offset, whence int) (int, error) {
 	}
 }
 
-// SetDot sets both ends of the dot into an absolute position. It will check the given offsets and adjust them accordingly, so they are not out of bounds or on an invalid rune start. It returns the final offsets. Error is always nil.
-func (b *Buffer) SetDot(q0, q1 int) (int, int, error) {
+// SetDot sets both ends of the dot into an absolute position. It will check the given offsets and adjust them accordingly, so they are not out of bounds or, unless the buffer is binary, on an invalid rune start. It returns the final offsets. Error is always nil.
+func (b *Buffer) SetDot(q0, q1 int64) (int64, int64, error) {
 	b.initBuffer()
 
 	b.q0, b.q1 = q0, q1
@@ -428,6 +878,10 @@ func (b *Buffer) SetDot(q0, q1 int) (int, int, error) {
 		b.q1 = b.q0
 	}
 
+	if b.Binary() {
+		return b.q0, b.q1, nil
+	}
+
 	// set only to valid rune start
 	var c byte
 	c, _ = b.buf.ByteAt(b.q0)
@@ -445,7 +899,7 @@ func (b *Buffer) SetDot(q0, q1 int) (int, int, error) {
 }
 
 // ExpandDot expands the current selection in positive or negative offset. A positive offset expands forwards and a negative expands backwards. Q is 0 or 1, either the left or the right end of the dot.
-func (b *Buffer) ExpandDot(q, offset int) {
+func (b *Buffer) ExpandDot(q int, offset int64) {
 	if q < 0 || q > 1 {
 		return
 	}
@@ -464,7 +918,7 @@ func (b *Buffer) ExpandDot(q, offset int) {
 // If on newline, select the whole line.
 //
 // Otherwise, select word (longest alphanumeric sequence).
-func (b *Buffer) Select(offset int) {
+func (b *Buffer) Select(offset int64) {
 	offset, _ = b.Seek(offset, io.SeekStart)
 	start, end := offset, offset
 
@@ -480,14 +934,14 @@ func (b *Buffer) Select(offset int) {
 	if start == end {
 		b.Seek(offset, io.SeekStart)
 		_, size, _ := b.ReadRune()
-		end += size
+		end += int64(size)
 	}
 
 	// Set dot
 	b.SetDot(start, end)
 }
 
-func (b *Buffer) NextSpace(offset int) (n int) {
+func (b *Buffer) NextSpace(offset int64) (n int64) {
 	offset, _ = b.Seek(offset, io.SeekStart)
 
 	r, size, err := b.ReadRune()
@@ -495,7 +949,7 @@ func (b *Buffer) NextSpace(offset int) (n int) {
 		return 0
 	}
 	for !unicode.IsSpace(r) {
-		n += size
+		n += int64(size)
 		r, size, err = b.ReadRune()
 		if err != nil {
 			if err == io.EOF {
@@ -508,7 +962,7 @@ func (b *Buffer) NextSpace(offset int) (n int) {
 	return n
 }
 
-func (b *Buffer) PrevSpace(offset int) (n int) {
+func (b *Buffer) PrevSpace(offset int64) (n int64) {
 	offset, _ = b.Seek(offset, io.SeekStart)
 
 	r, size, err := b.ReadRuneAt(offset)
@@ -518,21 +972,21 @@ func (b *Buffer) PrevSpace(offset int) (n int) {
 	for !unicode.IsSpace(r) {
 		r, size, err = b.UnreadRune()
 		if err != nil {
-			if err == gapbuffer.ErrOutOfRange {
+			if outOfRange(err) {
 				return n
 			}
 		}
-		n += size
+		n += int64(size)
 	}
 
 	if n > 0 {
-		n -= size // remove last iteration
+		n -= int64(size) // remove last iteration
 	}
 
 	return n
 }
 
-func (b *Buffer) NextWord(offset int) (n int) {
+func (b *Buffer) NextWord(offset int64) (n int64) {
 	offset, _ = b.Seek(offset, io.SeekStart)
 
 	r, size, err := b.ReadRune()
@@ -540,7 +994,7 @@ func (b *Buffer) NextWord(offset int) (n int) {
 		return 0
 	}
 	for unicode.IsLetter(r) || unicode.IsDigit(r) {
-		n += size
+		n += int64(size)
 		r, size, err = b.ReadRune()
 		if err != nil {
 			if err == io.EOF {
@@ -553,25 +1007,41 @@ func (b *Buffer) NextWord(offset int) (n int) {
 	return n
 }
 
-func (b *Buffer) PrevWord(offset int) (n int) {
+func (b *Buffer) PrevWord(offset int64) (n int64) {
 	offset, _ = b.Seek(offset, io.SeekStart)
 
 	r, size, _ := b.ReadRuneAt(offset)
 	for unicode.IsLetter(r) || unicode.IsDigit(r) {
 		r, size, _ = b.UnreadRune()
-		n += size
+		n += int64(size)
 	}
 
 	if n > 0 {
-		n -= size // remove last iteration
+		n -= int64(size) // remove last iteration
 	}
 
 	return n
 }
 
 // NextDelim returns number of bytes from given offset up until next delimiter.
-func (b *Buffer) NextDelim(delim rune, offset int) (n int) {
-	b.Seek(offset, io.SeekStart)
+func (b *Buffer) NextDelim(delim rune, offset int64) (n int64) {
+	offset, _ = b.Seek(offset, io.SeekStart)
+
+	if delim < utf8.RuneSelf && b.Paged() { // search the pages instead of decoding runes
+		if i := b.indexByte(byte(delim), offset); i >= 0 {
+			return i - offset
+		}
+		return b.Len() - offset
+	}
+	if delim == '\n' { // look it up instead
+		if offset >= b.Len() {
+			return 0
+		}
+		if k := b.buf.LineOf(offset); k < b.buf.LineCount()-1 {
+			return b.buf.LineStart(k+1) - 1 - offset
+		}
+		return b.Len() - offset
+	}
 
 	r, size, err := b.ReadRune()
 	if err != nil {
@@ -579,7 +1049,7 @@ func (b *Buffer) NextDelim(delim rune, offset int) (n int) {
 	}
 
 	for r != delim {
-		n += size
+		n += int64(size)
 		r, size, err = b.ReadRune()
 		if err != nil {
 			if err == io.EOF {
@@ -593,19 +1063,35 @@ func (b *Buffer) NextDelim(delim rune, offset int) (n int) {
 }
 
 // PrevDelim returns number of bytes from given offset up until next delimiter.
-func (b *Buffer) PrevDelim(delim rune, offset int) (n int) {
-	b.Seek(offset, io.SeekStart)
+func (b *Buffer) PrevDelim(delim rune, offset int64) (n int64) {
+	offset, _ = b.Seek(offset, io.SeekStart)
+
+	if delim < utf8.RuneSelf && b.Paged() { // search the pages instead of decoding runes
+		if i := b.lastIndexByte(byte(delim), offset); i >= 0 {
+			return offset - i
+		}
+		return offset
+	}
+	if delim == '\n' { // look it up instead
+		if offset > b.Len() {
+			return 0
+		}
+		if k := b.buf.LineOf(offset); k > 0 {
+			return offset - (b.buf.LineStart(k) - 1)
+		}
+		return offset
+	}
 	r, size, err := b.UnreadRune()
 	if err != nil {
 		return 0
 	}
-	n += size
+	n += int64(size)
 
 	for r != delim {
 		r, size, err = b.UnreadRune()
-		n += size
+		n += int64(size)
 		if err != nil {
-			if err == gapbuffer.ErrOutOfRange {
+			if outOfRange(err) {
 				return n
 			}
 			return 0
@@ -615,108 +1101,126 @@ func (b *Buffer) PrevDelim(delim rune, offset int) (n int) {
 	return n
 }
 
+// Undo reverts the last change set and selects the text it affected.
 func (b *Buffer) Undo() error {
-	c, err := b.history.Undo()
+	cs, err := b.history.Undo()
 	if err != nil {
 		return errors.Wrap(err, "undo")
 	}
-	b.commit(c)
-
-	// highlight text
-	b.SetDot(c.offset, c.offset+len(c.content))
-
-	return nil
+	return b.apply(cs)
 }
 
+// Redo applies the last undone change set again. If several branches have been undone, the one last visited is redone.
 func (b *Buffer) Redo() error {
-	c, err := b.history.Redo()
+	cs, err := b.history.Redo()
 	if err != nil {
 		return errors.Wrap(err, "redo")
 	}
-	b.commit(c)
+	return b.apply(cs)
+}
 
-	if c.action == HDelete {
-		b.SetDot(c.offset, c.offset)
-	} else {
-		b.SetDot(c.offset+len(c.content), c.offset+len(c.content))
+// Branch switches to the n:th next branch of the undo tree at the current state, or the n:th previous one if n is negative. Branches are created by undoing something and then making a new change.
+func (b *Buffer) Branch(n int) error {
+	cs, err := b.history.Branch(n)
+	if err != nil {
+		return errors.Wrap(err, "branch")
 	}
-	return nil
+	return b.apply(cs)
 }
 
-func (b *Buffer) commit(c Change) (int, error) {
-	b.initBuffer()
-
-	switch c.action {
-	case HInsert:
-		b.buf.Seek(c.offset) // sync gap buffer
-		n, err := b.buf.Write([]byte(c.content))
-		if err != nil {
-			return 0, err
-		}
-		return n, err
-	case HDelete:
-		n := len(c.content)
-		b.buf.Seek(c.offset + n) // sync gap buffer
-		for i := n; i > 0; i-- {
-			b.buf.Delete() // gap buffer deletes one byte at a time
-		}
-		return n, nil
-	default:
-		return 0, errors.New("invalid action in change")
+// Step moves n states forwards or backwards in history, in the order they were created and regardless of branch.
+func (b *Buffer) Step(n int) error {
+	cs, err := b.history.Step(n)
+	if err != nil {
+		return errors.Wrap(err, "step")
 	}
+	return b.apply(cs)
 }
 
-/* History */
-type HistoryAction uint8
-
-const (
-	HInsert HistoryAction = iota
-	HDelete
-)
+// Earlier returns the text to how it was d ago.
+func (b *Buffer) Earlier(d time.Duration) error {
+	cs, err := b.history.Travel(time.Now().Add(-d))
+	if err != nil {
+		return errors.Wrap(err, "earlier")
+	}
+	return b.apply(cs)
+}
 
-type Change struct {
-	offset  int
-	action  HistoryAction
-	content []byte
+// Later is the opposite of Earlier, and brings the text to how it was d after the last change to the current state. Counting from now would always end up at the newest state.
+func (b *Buffer) Later(d time.Duration) error {
+	cs, err := b.history.Travel(b.history.Time().Add(d))
+	if err != nil {
+		return errors.Wrap(err, "later")
+	}
+	return b.apply(cs)
 }
 
-type ChangeSet []*Change
+// apply commits the changes from history and selects the text they affected.
+func (b *Buffer) apply(cs ChangeSet) error {
+	for _, c := range cs {
+		if _, err := b.commit(*c); err != nil {
+			return err
+		}
+	}
+	if b.what == BufferFile {
+		b.dirty = true
+	}
 
-type History struct {
-	done   []Change
-	recall []Change
+	// highlight text
+	b.SetDot(cs.span())
+	return nil
 }
 
-func (h *History) Do(c Change) {
-	h.done = append(h.done, c)
-	h.recall = nil // clear old recall stack on new do
+// do commits c to the buffer and stores it in the history.
+func (b *Buffer) do(c Change) (int, error) {
+	n, err := b.commit(c)
+	if err != nil {
+		return n, err
+	}
+	b.history.Do(c)
+	if b.what == BufferFile {
+		b.dirty = true
+	}
+	return n, nil
 }
 
-func (h *History) Undo() (Change, error) {
-	if len(h.done) == 0 {
-		return Change{}, errors.New("no history")
+// typed commits c like do, but lets history join it with the typing before.
+func (b *Buffer) typed(c Change) (int, error) {
+	n, err := b.commit(c)
+	if err != nil {
+		return n, err
 	}
-	lastdone := h.done[len(h.done)-1]
-	h.recall = append(h.recall, lastdone)
-	h.done = h.done[:len(h.done)-1] // remove last one
-
-	// Reverse the done action so the returned change can be applied directly.
-	switch lastdone.action {
-	case HInsert:
-		lastdone.action = HDelete
-	case HDelete:
-		lastdone.action = HInsert
+	b.history.Type(c)
+	if b.what == BufferFile {
+		b.dirty = true
 	}
-
-	return lastdone, nil
+	return n, nil
 }
 
-func (h *History) Redo() (Change, error) {
-	if len(h.recall) == 0 {
-		return Change{}, errors.New("no recall history")
+func (b *Buffer) commit(c Change) (int, error) {
+	b.initBuffer()
+
+	switch c.action {
+	case HInsert:
+		b.buf.Seek(c.offset, io.SeekStart) // sync gap buffer
+		n, err := b.buf.Write([]byte(c.content))
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range b.marks {
+			*m = inserted(*m, c.offset, int64(n))
+		}
+		return n, err
+	case HDelete:
+		n, err := b.buf.DeleteRange(c.offset, int64(len(c.content)))
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range b.marks {
+			*m = deleted(*m, c.offset, n)
+		}
+		return int(n), nil
+	default:
+		return 0, errors.New("invalid action in change")
 	}
-	lastrecall := h.recall[len(h.recall)-1]
-	h.done = append(h.done, lastrecall)
-	h.recall = h.recall[:len(h.recall)-1] //remove last one
-	return lastrecall, nil
 }
@@ -0,0 +1,40 @@
+package editor_test
+
+import (
+	"testing"
+
+	"github.com/prodhe/poe/editor"
+)
+
+func TestLineCol(t *testing.T) {
+	var buf editor.Buffer
+	buf.Write([]byte("héllo\nwörld\n"))
+	buf.SetDot(3, 3)
+	buf.Write([]byte("\n")) // hé\nllo\nwörld\n
+
+	if n := buf.LineCount(); n != 4 {
+		t.Errorf("expected 4 lines, got %d", n)
+	}
+	var tt = []struct {
+		offset    int64
+		line, col int64
+	}{
+		{0, 0, 0},
+		{3, 0, 2}, // after é
+		{4, 1, 0},
+		{9, 2, 1},
+		{11, 2, 2}, // after ö
+		{15, 3, 0},
+	}
+	for _, tc := range tt {
+		if line, col := buf.OffsetToLineCol(tc.offset); line != tc.line || col != tc.col {
+			t.Errorf("offset %d: expected line %d col %d, got line %d col %d", tc.offset, tc.line, tc.col, line, col)
+		}
+		if got := buf.LineOf(tc.offset); got != tc.line {
+			t.Errorf("offset %d: expected line %d, got %d", tc.offset, tc.line, got)
+		}
+	}
+	if q := buf.LineStart(2); q != 8 {
+		t.Errorf("expected line 2 to start at 8, got %d", q)
+	}
+}
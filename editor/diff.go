@@ -0,0 +1,183 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// hunk is a difference between two sequences of lines, where lines a0 to a1 of the first are replaced by lines b0 to b1 of the second.
+type hunk struct {
+	a0, a1 int
+	b0, b1 int
+}
+
+// maxDiff is the largest number of differing lines diffLines tries to find exactly. Anything beyond that is treated as one big replacement.
+const maxDiff = 2000
+
+// splitLines splits text into lines, each including its terminating newline. The last line has no newline if text does not end with one.
+func splitLines(text []byte) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		lines = append(lines, string(text[:i]))
+		text = text[i:]
+	}
+	return lines
+}
+
+// diffLines returns the hunks that turn a into b, in order. It uses the algorithm by Myers to find the shortest edit.
+func diffLines(a, b []string) []hunk {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	hs := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	for i := range hs {
+		hs[i].a0 += pre
+		hs[i].a1 += pre
+		hs[i].b0 += pre
+		hs[i].b1 += pre
+	}
+	return hs
+}
+
+// myers returns the hunks of the shortest edit from a to b, or a single hunk replacing all of a if it needs more than maxDiff lines to be deleted or inserted.
+func myers(a, b []string) []hunk {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	// v[off+k] is the furthest x reached on diagonal k, and trace[d] the part of v in use after d differences
+	off := maxDiff + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= maxDiff; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m, d)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return []hunk{{0, n, 0, m}}
+}
+
+// backtrack follows the trace of myers from the end of both sequences back to the start and returns the hunks on the way.
+func backtrack(trace [][]int, x, y, d int) []hunk {
+	var hs []hunk
+	for ; d > 0; d-- {
+		vp := trace[d-1] // diagonals -(d-1) to d-1
+		k := x - y
+		var pk int
+		if k == -d || k != d && vp[k-1+d-1] < vp[k+1+d-1] {
+			pk = k + 1 // line inserted from b
+		} else {
+			pk = k - 1 // line deleted from a
+		}
+		px := vp[pk+d-1]
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+		}
+		x, y = px, py
+
+		h := hunk{x, x, y, y}
+		if pk == k+1 {
+			h.b1++
+		} else {
+			h.a1++
+		}
+		if len(hs) > 0 && hs[len(hs)-1].a0 == h.a1 && hs[len(hs)-1].b0 == h.b1 {
+			hs[len(hs)-1].a0, hs[len(hs)-1].b0 = h.a0, h.b0
+		} else {
+			hs = append(hs, h)
+		}
+	}
+	for i, j := 0, len(hs)-1; i < j; i, j = i+1, j-1 {
+		hs[i], hs[j] = hs[j], hs[i]
+	}
+	return hs
+}
+
+// unified returns the hunks between a and b as a unified diff with three lines of context.
+func unified(aname, bname string, a, b []string, hs []hunk) string {
+	const ctx = 3
+	if len(hs) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "--- %s\n+++ %s\n", aname, bname)
+	for i := 0; i < len(hs); {
+		// join hunks with overlapping context
+		j := i + 1
+		for j < len(hs) && hs[j].a0-hs[j-1].a1 <= 2*ctx {
+			j++
+		}
+		first, last := hs[i], hs[j-1]
+		a0, b0 := first.a0-ctx, first.b0-ctx
+		if a0 < 0 {
+			a0, b0 = 0, first.b0-first.a0
+		}
+		a1, b1 := last.a1+ctx, last.b1+ctx
+		if a1 > len(a) {
+			a1, b1 = len(a), last.b1+len(a)-last.a1
+		}
+		fmt.Fprintf(&s, "@@ -%s +%s @@\n", lineRange(a0, a1), lineRange(b0, b1))
+
+		q := a0
+		for _, h := range hs[i:j] {
+			writeLines(&s, ' ', a[q:h.a0])
+			writeLines(&s, '-', a[h.a0:h.a1])
+			writeLines(&s, '+', b[h.b0:h.b1])
+			q = h.a1
+		}
+		writeLines(&s, ' ', a[q:a1])
+		i = j
+	}
+	return s.String()
+}
+
+// lineRange formats the lines l0 to l1 for a hunk header of a unified diff.
+func lineRange(l0, l1 int) string {
+	switch l1 - l0 {
+	case 0:
+		return fmt.Sprintf("%d,0", l0)
+	case 1:
+		return fmt.Sprintf("%d", l0+1)
+	default:
+		return fmt.Sprintf("%d,%d", l0+1, l1-l0)
+	}
+}
+
+func writeLines(s *strings.Builder, prefix byte, lines []string) {
+	for _, l := range lines {
+		s.WriteByte(prefix)
+		s.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			s.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
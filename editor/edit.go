@@ -0,0 +1,474 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The edit language is the structural regular expression command language of sam and acme. A command operates on a range of text, initially the dot of the buffer. Loops and conditionals narrow down the range before handing it to the next command.
+//
+//	x/re/ cmd   run cmd on each match of re
+//	y/re/ cmd   run cmd on each part between matches of re
+//	g/re/ cmd   run cmd if the range contains a match of re
+//	v/re/ cmd   run cmd if the range does not contain a match of re
+//	a/text/     append text after the range
+//	i/text/     insert text before the range
+//	c/text/     change the range to text
+//	d           delete the range
+//	s/re/text/  substitute the first match of re with text, or all of them with a trailing g
+//	p           print the range
+//	=           print the line numbers of the range, or rune offsets with =#
+//	{ cmd ... } run every command on the same range
+//	! cmd       run the external command cmd and print its output
+//	< cmd       replace the range with the output of cmd
+//	> cmd       send the range to cmd on standard input and print its output
+//	| cmd       replace the range with the output of cmd, given the range as input
+//
+// A command may be preceded by an address, see address.go, which is then evaluated relative to the range and used instead. An address without a command sets dot.
+//
+// Any punctuation can be used as delimiter. An empty regular expression reuses the last one. In text, \n is a newline and \t a tab. In the replacement of s, & is the match and \1 to \9 are submatches.
+//
+// Changes are collected while the command runs and applied to the buffer when it is done, so every command sees the text as it was before the edit.
+
+// cmd is a parsed command of the edit language.
+type cmd struct {
+	addr   *addr
+	name   byte // 0 for an address without a command
+	re     *regexp.Regexp
+	text   string // argument to a, c, i, s and the external commands
+	global bool   // s with a trailing g
+	sub    *cmd   // command run by x, y, g and v
+	block  []*cmd // commands inside { }
+}
+
+// parser reads commands from a string.
+type parser struct {
+	s      string
+	pos    int
+	lastre *regexp.Regexp
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.peek()
+	if !p.eof() {
+		p.pos++
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// parse reads a sequence of commands up until end of input.
+func (p *parser) parse() ([]*cmd, error) {
+	var cmds []*cmd
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return cmds, nil
+		}
+		c, err := p.parseCmd()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+func (p *parser) parseCmd() (*cmd, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, errors.New("missing command")
+	}
+
+	a, err := p.parseAddr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if a != nil && (p.eof() || p.peek() == '}') {
+		return &cmd{addr: a}, nil
+	}
+
+	c := &cmd{addr: a, name: p.next()}
+	switch c.name {
+	case 'a', 'c', 'i':
+		if c.text, err = p.delimited(); err != nil {
+			return nil, err
+		}
+		c.text = unescape(c.text)
+	case 'd', 'p', 'f':
+	case '=':
+		if p.peek() == '#' {
+			c.text = string(p.next())
+		}
+	case 's':
+		var d byte
+		if d, err = p.delimiter(); err != nil {
+			return nil, err
+		}
+		if c.re, err = p.compile(p.until(d)); err != nil {
+			return nil, err
+		}
+		c.text = p.until(d)
+		if p.peek() == 'g' {
+			p.next()
+			c.global = true
+		}
+	case 'x', 'y', 'g', 'v':
+		var s string
+		if s, err = p.delimited(); err != nil {
+			return nil, err
+		}
+		if c.re, err = p.compile(s); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() || p.peek() == '}' {
+			if c.name == 'g' || c.name == 'v' {
+				return nil, errors.New("missing command")
+			}
+			c.sub = &cmd{name: 'p'} // loops print by default
+			break
+		}
+		if c.sub, err = p.parseCmd(); err != nil {
+			return nil, err
+		}
+	case '{':
+		for {
+			p.skipSpace()
+			if p.eof() {
+				return nil, errors.New("missing }")
+			}
+			if p.peek() == '}' {
+				p.next()
+				break
+			}
+			sub, err := p.parseCmd()
+			if err != nil {
+				return nil, err
+			}
+			c.block = append(c.block, sub)
+		}
+	case '!', '<', '>', '|':
+		c.text = strings.TrimSpace(p.line())
+	default:
+		return nil, fmt.Errorf("unknown command `%c'", c.name)
+	}
+
+	return c, nil
+}
+
+// line returns the rest of the current line.
+func (p *parser) line() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// delimiter reads and verifies the delimiter of a regular expression or text argument.
+func (p *parser) delimiter() (byte, error) {
+	d := p.next()
+	c := rune(d)
+	if c == 0 || c == '\\' || unicode.IsSpace(c) || unicode.IsLetter(c) || unicode.IsDigit(c) {
+		return 0, errors.New("bad delimiter")
+	}
+	return d, nil
+}
+
+// delimited reads a delimiter and returns the text up until the next unescaped occurrence of it.
+func (p *parser) delimited() (string, error) {
+	d, err := p.delimiter()
+	if err != nil {
+		return "", err
+	}
+	return p.until(d), nil
+}
+
+// until returns the text up until the next unescaped d and skips past it. An escaped delimiter is returned without its backslash. Other escapes are left for later stages. A missing closing delimiter at end of input is accepted.
+func (p *parser) until(d byte) string {
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.next()
+		switch {
+		case c == d:
+			return sb.String()
+		case c == '\\' && p.peek() == d:
+			sb.WriteByte(p.next())
+		case c == '\\' && !p.eof():
+			sb.WriteByte(c)
+			sb.WriteByte(p.next())
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// compile compiles s in multi-line mode, so ^ and $ match at line boundaries. An empty s returns the last compiled regular expression.
+func (p *parser) compile(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		if p.lastre == nil {
+			return nil, errors.New("no previous regular expression")
+		}
+		return p.lastre, nil
+	}
+	re, err := regexp.Compile("(?m)" + s)
+	if err != nil {
+		return nil, err
+	}
+	p.lastre = re
+	return re, nil
+}
+
+// unescape translates \n, \t and \\ in text arguments.
+func unescape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '\\':
+				sb.WriteByte('\\')
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(s[i])
+			}
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// expand returns the replacement text for the match m in src, substituting & and \0 to \9.
+func expand(repl string, src []byte, m []int) []byte {
+	var b []byte
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		switch {
+		case c == '&':
+			b = append(b, src[m[0]:m[1]]...)
+		case c == '\\' && i+1 < len(repl):
+			i++
+			c = repl[i]
+			switch {
+			case c >= '0' && c <= '9':
+				n := int(c-'0') * 2
+				if n+1 < len(m) && m[n] >= 0 {
+					b = append(b, src[m[n]:m[n+1]]...)
+				}
+			case c == 'n':
+				b = append(b, '\n')
+			case c == 't':
+				b = append(b, '\t')
+			default:
+				b = append(b, c)
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// change is a pending replacement of q0,q1 with text.
+type change struct {
+	q0, q1 int64
+	text   []byte
+}
+
+// edit holds the state of a command running on a buffer.
+type edit struct {
+	ed      *ed
+	bufid   int64
+	buf     *Buffer
+	text    []byte // content of buf when the command started
+	changes []change
+	out     strings.Builder
+}
+
+func (x *edit) change(q0, q1 int64, text []byte) {
+	x.changes = append(x.changes, change{q0, q1, text})
+}
+
+// address evaluates the address of c relative to the range q0,q1. The range is returned as is if c has no address.
+func (x *edit) address(c *cmd, q0, q1 int64) (int64, int64, error) {
+	if c.addr == nil {
+		return q0, q1, nil
+	}
+	r, err := address(textBytes(x.text), c.addr, rng{q0, q1}, 0)
+	return r.q0, r.q1, err
+}
+
+// run runs c on its address relative to the range q0,q1.
+func (x *edit) run(c *cmd, q0, q1 int64) error {
+	q0, q1, err := x.address(c, q0, q1)
+	if err != nil {
+		return err
+	}
+	return x.exec(c, q0, q1)
+}
+
+// exec runs c on the range q0,q1, ignoring the address of c.
+func (x *edit) exec(c *cmd, q0, q1 int64) error {
+	switch c.name {
+	case 'a':
+		x.change(q1, q1, []byte(c.text))
+	case 'i':
+		x.change(q0, q0, []byte(c.text))
+	case 'c':
+		x.change(q0, q1, []byte(c.text))
+	case 'd':
+		x.change(q0, q1, nil)
+	case 'p':
+		x.out.Write(x.text[q0:q1])
+	case '=':
+		if c.text == "#" {
+			fmt.Fprintf(&x.out, "%s:#%d,#%d\n", x.buf.Name(), utf8.RuneCount(x.text[:q0]), utf8.RuneCount(x.text[:q1]))
+			break
+		}
+		l0 := 1 + bytes.Count(x.text[:q0], []byte{'\n'})
+		l1 := l0 + bytes.Count(x.text[q0:q1], []byte{'\n'})
+		if l1 > l0 && x.text[q1-1] == '\n' {
+			l1--
+		}
+		if l0 == l1 {
+			fmt.Fprintf(&x.out, "%s:%d\n", x.buf.Name(), l0)
+			break
+		}
+		fmt.Fprintf(&x.out, "%s:%d,%d\n", x.buf.Name(), l0, l1)
+	case 's':
+		src := x.text[q0:q1]
+		n := 1
+		if c.global {
+			n = -1
+		}
+		for _, m := range c.re.FindAllSubmatchIndex(src, n) {
+			x.change(q0+int64(m[0]), q0+int64(m[1]), expand(c.text, src, m))
+		}
+	case 'x':
+		for _, m := range c.re.FindAllIndex(x.text[q0:q1], -1) {
+			if err := x.run(c.sub, q0+int64(m[0]), q0+int64(m[1])); err != nil {
+				return err
+			}
+		}
+	case 'y':
+		p := q0
+		for _, m := range c.re.FindAllIndex(x.text[q0:q1], -1) {
+			if err := x.run(c.sub, p, q0+int64(m[0])); err != nil {
+				return err
+			}
+			p = q0 + int64(m[1])
+		}
+		return x.run(c.sub, p, q1)
+	case 'g', 'v':
+		if c.re.Match(x.text[q0:q1]) == (c.name == 'g') {
+			return x.run(c.sub, q0, q1)
+		}
+	case '{':
+		for _, sub := range c.block {
+			if err := x.run(sub, q0, q1); err != nil {
+				return err
+			}
+		}
+	case 'f':
+		var names []string
+		for _, buf := range x.ed.buffers {
+			names = append(names, buf.Name())
+		}
+		fmt.Fprintf(&x.out, "buffers:\n%s", strings.Join(names, "\n"))
+	case '!':
+		out, err := x.pipe(c.text, q0, q1, false)
+		x.out.Write(out)
+		if err != nil {
+			fmt.Fprintf(&x.out, "%s: %s\n", c.text, err)
+		}
+	case '<':
+		out, err := x.pipe(c.text, q0, q1, false)
+		if err != nil {
+			return err
+		}
+		x.change(q0, q1, out)
+	case '>':
+		out, err := x.pipe(c.text, q0, q1, true)
+		x.out.Write(out)
+		if err != nil {
+			return err
+		}
+	case '|':
+		out, err := x.pipe(c.text, q0, q1, true)
+		if err != nil {
+			return err
+		}
+		x.change(q0, q1, out)
+	}
+	return nil
+}
+
+// pipe runs an external command on the range q0,q1 and returns its output. If input is true, the range is given as standard input. Standard error is printed.
+func (x *edit) pipe(command string, q0, q1 int64, input bool) ([]byte, error) {
+	c := x.ed.command(x.bufid, q0, q1, command)
+	if input {
+		c.Stdin = bytes.NewReader(x.text[q0:q1])
+	}
+	c.Stderr = &x.out
+	return c.Output()
+}
+
+// apply applies the collected changes to the buffer. They are applied from the end, so the offsets of the ones before remain valid. It returns the range of the changed text in the new buffer and false if nothing changed.
+func (x *edit) apply() (q0, q1 int64, ok bool, err error) {
+	cs := x.changes
+	if len(cs) == 0 {
+		return 0, 0, false, nil
+	}
+
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].q0 < cs[j].q0 || cs[i].q0 == cs[j].q0 && cs[i].q1 < cs[j].q1
+	})
+	for i := 1; i < len(cs); i++ {
+		if cs[i].q0 < cs[i-1].q1 {
+			return 0, 0, false, errors.New("changes not in sequence")
+		}
+	}
+
+	for i := len(cs) - 1; i >= 0; i-- {
+		if _, err := x.buf.Replace(cs[i].q0, cs[i].q1, cs[i].text); err != nil {
+			return 0, 0, false, err
+		}
+	}
+
+	// the last change has moved by the length difference of all before it
+	last := cs[len(cs)-1]
+	q1 = last.q0 + int64(len(last.text))
+	for _, c := range cs[:len(cs)-1] {
+		q1 += int64(len(c.text)) - (c.q1 - c.q0)
+	}
+	return cs[0].q0, q1, true, nil
+}
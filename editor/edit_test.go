@@ -0,0 +1,244 @@
+package editor_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prodhe/poe/editor"
+)
+
+func TestEdit(t *testing.T) {
+	var tt = []struct {
+		name    string
+		input   string
+		q0, q1  int64
+		cmd     string
+		want    string
+		wantout string
+	}{
+		{"change", "hello gopher", 0, 5, "c/bye/", "bye gopher", ""},
+		{"append", "hello", 0, 5, "a/ gopher/", "hello gopher", ""},
+		{"insert", "gopher", 0, 6, "i/hello /", "hello gopher", ""},
+		{"delete", "hello gopher", 5, 12, "d", "hello", ""},
+		{"print", "hello gopher", 6, 12, "p", "hello gopher", "gopher"},
+		{"x change", "a b a b", 0, 7, "x/a/c/c/", "c b c b", ""},
+		{"x default print", "a1 b2 a3", 0, 8, "x/a./", "a1 b2 a3", "a1a3"},
+		{"y", "a,b,c", 0, 5, "y/,/ c/x/", "x,x,x", ""},
+		{"g", "foo\nbar\n", 0, 8, "x/.*\\n/ g/foo/d", "bar\n", ""},
+		{"v", "foo\nbar\n", 0, 8, "x/.*\\n/ v/foo/d", "foo\n", ""},
+		{"s first", "aaa", 0, 3, "s/a/b/", "baa", ""},
+		{"s global", "aaa", 0, 3, "s/a/b/g", "bbb", ""},
+		{"s submatch", "key=value", 0, 9, "s/(.*)=(.*)/\\2=\\1/", "value=key", ""},
+		{"s ampersand", "go", 0, 2, "s/go/<&>/", "<go>", ""},
+		{"block", "a b", 0, 3, "x/[ab]/ { i/</ a/>/ }", "<a> <b>", ""},
+		{"nested", "ab\ncd\n", 0, 6, "x/.*\\n/ x/^./ c/X/", "Xb\nXd\n", ""},
+		{"newline in text", "ab", 0, 2, "x/a/ a/\\n/", "a\nb", ""},
+		{"escaped delimiter", "a/b", 0, 3, "s/\\//-/", "a-b", ""},
+		{"other delimiter", "a/b", 0, 3, "s,/,-,", "a-b", ""},
+		{"last regexp", "abc", 0, 3, "x/b/ s//B/", "aBc", ""},
+		{"sequence", "abc", 0, 3, "c/x/ a/y/", "xy", ""},
+		{"unknown", "abc", 0, 3, "z", "abc", "?unknown command `z'\n"},
+		{"overlap", "abc", 0, 3, "{ d c/x/ d }", "abc", "?changes not in sequence\n"},
+		{"missing brace", "abc", 0, 3, "{ d", "abc", "?missing }\n"},
+		{"bad delimiter", "abc", 0, 3, "c abc", "abc", "?bad delimiter\n"},
+	}
+
+	for _, tc := range tt {
+		e := editor.New()
+		id, buf := e.NewBuffer()
+		buf.Write([]byte(tc.input))
+		buf.SetDot(tc.q0, tc.q1)
+		out := e.Edit(id, tc.cmd)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: %q on %q: expected %q, got %q", tc.name, tc.cmd, tc.input, tc.want, got)
+		}
+		if out != tc.wantout {
+			t.Errorf("%s: %q on %q: expected output %q, got %q", tc.name, tc.cmd, tc.input, tc.wantout, out)
+		}
+	}
+}
+
+func TestEditDot(t *testing.T) {
+	e := editor.New()
+	id, buf := e.NewBuffer()
+	buf.Write([]byte("one two three"))
+	buf.SetDot(4, 7)
+	e.Edit(id, "c/2/")
+	if q0, q1 := buf.Dot(); q0 != 4 || q1 != 5 {
+		t.Errorf("expected dot 4,5, got %d,%d", q0, q1)
+	}
+	buf.SetDot(0, buf.Len())
+	e.Edit(id, "x/e/ c/EE/")
+	if q0, q1 := buf.Dot(); q0 != 2 || q1 != 14 {
+		t.Errorf("expected dot 2,14, got %d,%d", q0, q1)
+	}
+}
+
+// edit runs cmd and waits for any job it started to finish.
+func edit(e editor.Editor, id int64, cmd string) string {
+	out := e.Edit(id, cmd)
+	if len(e.Jobs()) == 0 {
+		return out
+	}
+	for ev := range e.JobEvents() {
+		out += e.HandleJobEvent(ev)
+		if ev.Done && len(e.Jobs()) == 0 {
+			break
+		}
+	}
+	return out
+}
+
+func TestEditPipe(t *testing.T) {
+	var tt = []struct {
+		name    string
+		cmd     string
+		want    string
+		wantout string
+	}{
+		{"replace", "<echo hello", "hello\n", ""},
+		{"filter", "|sort", "a\nb\nc\n", ""},
+		{"send", ">wc -l", "c\na\nb\n", "3\n"},
+		{"failure", "|false", "c\na\nb\n", "false: exit status 1\n"},
+		{"quoting", "<printf '%s' \"a  b\"", "a  b", ""},
+		{"pipeline", "<echo $((1+2)) | tr 3 x", "x\n", ""},
+		{"stderr", "!echo oops >&2; false", "c\na\nb\n", "oops\necho oops >&2; false: exit status 1\n"},
+		{"address", ",|sort", "a\nb\nc\n", ""},
+		{"filter each", ",x/.*\\n/ |tr a-z A-Z", "C\nA\nB\n", ""},
+		{"failure in edit", ",|false", "c\na\nb\n", "?exit status 1\n"},
+	}
+
+	for _, tc := range tt {
+		e := editor.New()
+		id, buf := e.NewBuffer()
+		buf.Write([]byte("c\na\nb\n"))
+		buf.SetDot(0, buf.Len())
+		out := edit(e, id, tc.cmd)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: %q: expected %q, got %q", tc.name, tc.cmd, tc.want, got)
+		}
+		if out != tc.wantout {
+			t.Errorf("%s: %q: expected output %q, got %q", tc.name, tc.cmd, tc.wantout, out)
+		}
+	}
+
+	// a filter is undone in one step
+	e := editor.New()
+	id, buf := e.NewBuffer()
+	buf.Write([]byte("c\na\nb\n"))
+	buf.SetDot(0, buf.Len())
+	edit(e, id, "|sort")
+	buf.Undo()
+	if got := buf.String(); got != "c\na\nb\n" {
+		t.Errorf("undo filter: expected %q, got %q", "c\na\nb\n", got)
+	}
+	buf.Redo()
+	if got := buf.String(); got != "a\nb\nc\n" {
+		t.Errorf("redo filter: expected %q, got %q", "a\nb\nc\n", got)
+	}
+
+	// the dot of a running filter follows edits made in the meantime
+	e = editor.New()
+	id, buf = e.NewBuffer()
+	buf.Write([]byte("abc def"))
+	buf.SetDot(4, 7)
+	e.Edit(id, "|tr a-z A-Z")
+	buf.SetDot(0, 0)
+	buf.Write([]byte("XY"))
+	for len(e.Jobs()) > 0 {
+		e.HandleJobEvent(<-e.JobEvents())
+	}
+	if got := buf.String(); got != "XYabc DEF" {
+		t.Errorf("edit while filtering: expected %q, got %q", "XYabc DEF", got)
+	}
+}
+
+func TestKill(t *testing.T) {
+	e := editor.New()
+	id, _ := e.NewBuffer()
+	e.Edit(id, "!sleep 10")
+	e.Edit(id, "!sleep 20")
+	if n := len(e.Jobs()); n != 2 {
+		t.Fatalf("expected 2 jobs, got %d", n)
+	}
+	if err := e.Kill("nosuchjob"); err == nil {
+		t.Errorf("expected error on killing unknown job")
+	}
+	if err := e.Kill("1"); err != nil {
+		t.Errorf("kill by id: %s", err)
+	}
+	ev := <-e.JobEvents()
+	e.HandleJobEvent(ev)
+	if jobs := e.Jobs(); len(jobs) != 1 || jobs[0].ID != 2 {
+		t.Errorf("expected job 2 to remain, got %v", jobs)
+	}
+	if err := e.Kill("sleep"); err != nil {
+		t.Errorf("kill by name: %s", err)
+	}
+	e.HandleJobEvent(<-e.JobEvents())
+	if n := len(e.Jobs()); n != 0 {
+		t.Errorf("expected no jobs, got %d", n)
+	}
+
+	// the whole pipeline is killed, not only the shell running it
+	e.Edit(id, "!sleep 10 | cat")
+	start := time.Now()
+	e.Kill("")
+	for len(e.Jobs()) > 0 {
+		e.HandleJobEvent(<-e.JobEvents())
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("kill pipeline: expected it to exit at once, took %s", d)
+	}
+}
+
+func TestEditDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	e := editor.New()
+	if err := e.SetWorkDir(filepath.Join(dir, "nosuchdir")); err == nil {
+		t.Errorf("expected error changing into missing directory")
+	}
+	if err := e.SetWorkDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	id, buf := e.NewBuffer()
+	edit(e, id, "<pwd")
+	if got := buf.String(); got != dir+"\n" {
+		t.Errorf("expected command to run in %q, got %q", dir, got)
+	}
+
+	// buffers keep the directory they were created in
+	e.SetWorkDir("..")
+	if got := e.Dir(id); got != dir {
+		t.Errorf("expected buffer dir %q, got %q", dir, got)
+	}
+}
+
+func TestEditEnv(t *testing.T) {
+	e := editor.New()
+	id, buf := e.NewBuffer()
+	buf.NewFile("/tmp/poe.txt")
+	buf.Write([]byte("hello gopher"))
+	buf.SetDot(6, 12)
+	out := edit(e, id, `>echo "$winid $samfile $POE_Q0 $POE_Q1 $POE_DIR"`)
+	want := fmt.Sprintf("%d /tmp/poe.txt 6 12 /tmp\n", id)
+	if out != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+
+	// ranges inside an edit get their own offsets
+	out = e.Edit(id, `,x/o/ >echo $POE_Q0`)
+	if out != "4\n7\n" {
+		t.Errorf("expected offsets of matches, got %q", out)
+	}
+}
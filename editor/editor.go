@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -19,27 +20,43 @@ type Editor interface {
 	LoadBuffers(filenames []string)
 	CloseBuffer(id int64)
 	WorkDir() string
+	SetWorkDir(dir string) error
+	Dir(bufid int64) string
 	Len() int
 	Edit(bufid int64, cmd string) string
+	Jobs() []*Job
+	Kill(name string) error
+	JobEvents() <-chan JobEvent
+	HandleJobEvent(ev JobEvent) string
+	Settings() *Settings
+	WriteSwaps() error
+	Poll() []int64
 }
 
 // New returns an empty editor with no buffers loaded.
 func New() Editor {
 	e := &ed{}
 	e.buffers = map[int64]*Buffer{}
+	e.jobs = map[int]*Job{}
+	e.events = make(chan JobEvent, 100)
 	e.workdir, _ = filepath.Abs(".")
 	return e
 }
 
 // ed implements Editor.
 type ed struct {
-	buffers map[int64]*Buffer
-	workdir string
+	buffers  map[int64]*Buffer
+	workdir  string
+	lastre   *regexp.Regexp // last regular expression used in Edit
+	jobs     map[int]*Job
+	lastjob  int // id of the last started job
+	events   chan JobEvent
+	settings Settings
 }
 
 // NewBuffer creates an empty buffer and appends it to the editor. Returns the new id and the new buffer.
 func (e *ed) NewBuffer() (id int64, buf *Buffer) {
-	buf = &Buffer{buf: &gapbuffer.Buffer{}}
+	buf = &Buffer{buf: &gapbuffer.Buffer{}, dir: e.WorkDir(), settings: &e.settings}
 	id = e.genBufferID()
 	e.buffers[id] = buf
 	return id, buf
@@ -64,11 +81,43 @@ func (e *ed) Buffers() ([]int64, []*Buffer) {
 	return ids, bs
 }
 
-// CloseBuffer deletes the given buffer from memory. No warnings. Here be dragons.
+// CloseBuffer deletes the given buffer from memory. No warnings. Here be dragons. The undo history is kept on disk if the settings say so and the buffer is unchanged, and its recovery file is removed.
 func (e *ed) CloseBuffer(id int64) {
+	if buf, ok := e.buffers[id]; ok {
+		buf.SaveHistory()
+		buf.RemoveSwap()
+		buf.Destroy() // lets go of the file of a paged buffer
+	}
 	delete(e.buffers, id)
 }
 
+// WriteSwaps writes the recovery files of all changed buffers. It returns the first error, but tries all buffers.
+func (e *ed) WriteSwaps() error {
+	var first error
+	for _, buf := range e.buffers {
+		if err := buf.WriteSwap(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Poll checks all buffers for changes to their files and directories on disk, and reads them again if they have no unsaved changes. It returns the ids of the buffers that have become stale, since their files changed while they had unsaved changes.
+func (e *ed) Poll() []int64 {
+	var stale []int64
+	for id, buf := range e.buffers {
+		if ok, _ := buf.Poll(); ok {
+			stale = append(stale, id)
+		}
+	}
+	return stale
+}
+
+// Settings returns the settings of the editor. Changes to them apply to all buffers.
+func (e *ed) Settings() *Settings {
+	return &e.settings
+}
+
 // Len returns number of buffers currently in the editor.
 func (e *ed) Len() int {
 	return len(e.buffers)
@@ -83,6 +132,32 @@ func (e *ed) WorkDir() string {
 	return e.workdir
 }
 
+// SetWorkDir changes the base working directory of the editor, which is used by buffers without a file created after the change. A relative dir is taken from the current working directory of the editor.
+func (e *ed) SetWorkDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(e.WorkDir(), dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	e.workdir = filepath.Clean(dir)
+	return nil
+}
+
+// Dir returns the directory that commands started from the given buffer run in. It is the working directory of the buffer, falling back to the one of the editor.
+func (e *ed) Dir(bufid int64) string {
+	if buf, ok := e.buffers[bufid]; ok {
+		if d := buf.WorkDir(); d != "" {
+			return d
+		}
+	}
+	return e.WorkDir()
+}
+
 // LoadBuffers reads files from disk and loads them into windows. Screen need to be initialized.
 func (e *ed) LoadBuffers(fns []string) {
 	// load given filenames and append to buffer list
@@ -97,40 +172,85 @@ func (e *ed) genBufferID() int64 {
 	return time.Now().UnixNano()
 }
 
+// Edit runs a command of the edit language on the buffer with the given id and returns its output. Errors are returned as output prefixed with a question mark.
+//
+// Several commands may be given in sequence. Each of them sees the result of the one before.
+//
+// A single external command on the dot, starting with one of !, <, > or |, is started as a job in the background. External commands as part of a larger edit run to completion before Edit returns.
 func (e *ed) Edit(bufid int64, args string) string {
-	if len(args) < 1 {
+	buf, ok := e.buffers[bufid]
+	if !ok {
+		// no such bufid
 		return ""
 	}
 
-	if _, ok := e.buffers[bufid]; !ok {
-		// no such bufid
+	p := &parser{s: args, lastre: e.lastre}
+	cmds, err := p.parse()
+	e.lastre = p.lastre
+	if err != nil {
+		return fmt.Sprintf("?%s\n", err)
+	}
+
+	if len(cmds) == 1 && cmds[0].addr == nil && strings.IndexByte("!<>|", cmds[0].name) >= 0 {
+		if err := e.start(bufid, cmds[0].name, cmds[0].text); err != nil {
+			return fmt.Sprintf("?%s\n", err)
+		}
 		return ""
 	}
 
-	switch args[0] {
-	case 'f':
-		var names []string
-		for _, buf := range e.buffers {
-			names = append(names, fmt.Sprintf("%s", buf.Name()))
+	// the whole edit is undone as one
+	buf.history.Begin()
+	defer buf.history.End()
+
+	var out strings.Builder
+	for _, c := range cmds {
+		x := &edit{ed: e, bufid: bufid, buf: buf, text: buf.buf.Bytes()}
+		dot0, dot1 := buf.Dot()
+		q0, q1, err := x.address(c, dot0, dot1)
+		if err == nil {
+			err = x.exec(c, q0, q1)
+		}
+		if err == nil {
+			var n0, n1 int64
+			if n0, n1, ok, err = x.apply(); ok {
+				q0, q1 = n0, n1
+			}
 		}
-		return fmt.Sprintf("buffers:\n%s", strings.Join(names, "\n"))
-	case '!':
-		os.Chdir(e.buffers[bufid].WorkDir())
-		cmd := strings.Split(string(args[1:]), " ")
-		path, err := exec.LookPath(cmd[0])
-		if err != nil { // path not found or not executable
-			//return fmt.Sprintf("cannot execute: %s", cmd[0])
-			return ""
+		if err == nil {
+			buf.SetDot(q0, q1) // the changed text or else the address
 		}
-		out, err := exec.Command(path, cmd[1:]...).Output()
+		out.WriteString(x.out.String())
 		if err != nil {
-			return fmt.Sprintf("error: %s", err)
+			fmt.Fprintf(&out, "?%s\n", err)
 			break
 		}
-		outstr := string(out)
-		return outstr
 	}
+	return out.String()
+}
+
+// command prepares an external command started from the buffer with the given id, working on the range q0,q1 in it. The command line is interpreted by the user's shell, or /bin/sh if $SHELL is not set.
+//
+// The command runs in the directory of the buffer. Like in acme, $winid holds the buffer id and $% and $samfile its file name. $POE_Q0 and $POE_Q1 are the byte offsets of the range and $POE_DIR the directory.
+func (e *ed) command(bufid int64, q0, q1 int64, command string) *exec.Cmd {
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	c := exec.Command(sh, "-c", command)
+	c.Dir = e.Dir(bufid)
+	setpgid(c)
 
-	// no match
-	return "?"
+	var name string
+	if buf, ok := e.buffers[bufid]; ok {
+		name = buf.Name()
+	}
+	c.Env = append(os.Environ(),
+		fmt.Sprintf("winid=%d", bufid),
+		fmt.Sprintf("%%=%s", name),
+		fmt.Sprintf("samfile=%s", name),
+		fmt.Sprintf("POE_Q0=%d", q0),
+		fmt.Sprintf("POE_Q1=%d", q1),
+		fmt.Sprintf("POE_DIR=%s", c.Dir),
+	)
+	return c
 }
@@ -0,0 +1,234 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Character encodings of files. The text of a buffer is always UTF-8, and files in other encodings are converted when read and written.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingLatin1      = "latin1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// encoding converts between UTF-8 and the bytes of a file.
+type encoding struct {
+	bom    []byte // byte order mark, if the encoding has one
+	decode func(data []byte) []byte
+	encode func(text []byte) ([]byte, error)
+	valid  func(data []byte) bool // whether data is decoded without loss, if it may not be
+}
+
+var encodings = map[string]*encoding{
+	EncodingUTF8: {
+		bom:    []byte("\xef\xbb\xbf"),
+		decode: func(data []byte) []byte { return data },
+		encode: func(text []byte) ([]byte, error) { return text, nil },
+	},
+	EncodingUTF16LE: {
+		bom:    []byte("\xff\xfe"),
+		decode: func(data []byte) []byte { return decodeUTF16(data, binary.LittleEndian) },
+		encode: func(text []byte) ([]byte, error) { return encodeUTF16(text, binary.LittleEndian), nil },
+		valid:  func(data []byte) bool { return validUTF16(data, binary.LittleEndian) },
+	},
+	EncodingUTF16BE: {
+		bom:    []byte("\xfe\xff"),
+		decode: func(data []byte) []byte { return decodeUTF16(data, binary.BigEndian) },
+		encode: func(text []byte) ([]byte, error) { return encodeUTF16(text, binary.BigEndian), nil },
+		valid:  func(data []byte) bool { return validUTF16(data, binary.BigEndian) },
+	},
+	EncodingLatin1: {
+		decode: func(data []byte) []byte { return decode8bit(data, nil) },
+		encode: func(text []byte) ([]byte, error) { return encode8bit(text, nil, EncodingLatin1) },
+	},
+	EncodingWindows1252: {
+		decode: func(data []byte) []byte { return decode8bit(data, &windows1252) },
+		encode: func(text []byte) ([]byte, error) { return encode8bit(text, &windows1252, EncodingWindows1252) },
+	},
+}
+
+// aliases are other names of the encodings.
+var aliases = map[string]string{
+	"utf8":       EncodingUTF8,
+	"utf16le":    EncodingUTF16LE,
+	"utf16be":    EncodingUTF16BE,
+	"latin-1":    EncodingLatin1,
+	"iso-8859-1": EncodingLatin1,
+	"iso8859-1":  EncodingLatin1,
+	"cp1252":     EncodingWindows1252,
+}
+
+// windows1252 holds the characters of Windows-1252 from 0x80 to 0x9f, where it differs from Latin-1. The five bytes that are undefined are kept as the control characters of Latin-1.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// LookupEncoding returns the name of the encoding called name, which may be one of its aliases in any case, like UTF8 or ISO-8859-1.
+func LookupEncoding(name string) (string, error) {
+	name = strings.ToLower(name)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if _, ok := encodings[name]; !ok {
+		return "", fmt.Errorf("unknown encoding: %s", name)
+	}
+	return name, nil
+}
+
+// detectEncoding guesses the encoding of data, and whether it starts with a byte order mark. A byte order mark decides, and otherwise valid UTF-8 is UTF-8. Text where every other byte is zero is taken for UTF-16, if it is valid and has no control characters but white space, and anything else for Windows-1252 if it uses any of the characters that differ from Latin-1, or else Latin-1.
+func detectEncoding(data []byte) (string, bool) {
+	for _, name := range []string{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE} {
+		if bytes.HasPrefix(data, encodings[name].bom) {
+			return name, true
+		}
+	}
+	if utf8.Valid(data) {
+		return EncodingUTF8, false
+	}
+
+	if len(data)%2 == 0 {
+		var even, odd int // number of zero bytes
+		for i := 0; i < len(data); i += 2 {
+			if data[i] == 0 {
+				even++
+			}
+			if data[i+1] == 0 {
+				odd++
+			}
+		}
+		switch {
+		case even == 0 && odd*2 >= len(data)/2 && textUTF16(data, binary.LittleEndian):
+			return EncodingUTF16LE, false
+		case odd == 0 && even*2 >= len(data)/2 && textUTF16(data, binary.BigEndian):
+			return EncodingUTF16BE, false
+		}
+	}
+
+	for _, c := range data {
+		if c >= 0x80 && c < 0xa0 {
+			return EncodingWindows1252, false
+		}
+	}
+	return EncodingLatin1, false
+}
+
+// binarySniff is how much of the start of a file is looked at to tell if it is binary.
+const binarySniff = 8000
+
+// isBinary returns true if data looks like a binary file rather than text in the encoding enc, which is when its start has a zero byte that is not part of UTF-16.
+func isBinary(data []byte, enc string) bool {
+	if enc == EncodingUTF16LE || enc == EncodingUTF16BE {
+		return false
+	}
+	if len(data) > binarySniff {
+		data = data[:binarySniff]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// textUTF16 returns true if data is valid UTF-16 in the given byte order without control characters other than white space, unlike binary data that happens to have every other byte zero.
+func textUTF16(data []byte, order binary.ByteOrder) bool {
+	if !validUTF16(data, order) {
+		return false
+	}
+	for _, r := range string(decodeUTF16(data, order)) {
+		if r < ' ' && !strings.ContainsRune("\t\n\v\f\r", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validUTF16 returns true if data is UTF-16 in the given byte order that is written back unchanged after being decoded, which it is not with a trailing odd byte or a surrogate without its pair.
+func validUTF16(data []byte, order binary.ByteOrder) bool {
+	if len(data)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(data); i += 2 {
+		switch c := order.Uint16(data[i:]); {
+		case c >= 0xd800 && c < 0xdc00: // high surrogate, followed by a low one
+			if i+4 > len(data) {
+				return false
+			}
+			if c := order.Uint16(data[i+2:]); c < 0xdc00 || c >= 0xe000 {
+				return false
+			}
+			i += 2
+		case c >= 0xdc00 && c < 0xe000:
+			return false
+		}
+	}
+	return true
+}
+
+// decodeUTF16 converts UTF-16 in the given byte order to UTF-8. A trailing odd byte and unpaired surrogates become the replacement character, which is why files with them are read as binary.
+func decodeUTF16(data []byte, order binary.ByteOrder) []byte {
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = order.Uint16(data[2*i:])
+	}
+	var text bytes.Buffer
+	text.Grow(len(data))
+	for _, r := range utf16.Decode(u) {
+		text.WriteRune(r)
+	}
+	if len(data)%2 != 0 {
+		text.WriteRune(utf8.RuneError)
+	}
+	return text.Bytes()
+}
+
+// encodeUTF16 converts UTF-8 to UTF-16 in the given byte order.
+func encodeUTF16(text []byte, order binary.ByteOrder) []byte {
+	u := utf16.Encode([]rune(string(text)))
+	data := make([]byte, 2*len(u))
+	for i, c := range u {
+		order.PutUint16(data[2*i:], c)
+	}
+	return data
+}
+
+// decode8bit converts a single byte encoding to UTF-8. Bytes from 0x80 to 0x9f are looked up in high, if any, and all others are the same as their code point, like in Latin-1.
+func decode8bit(data []byte, high *[32]rune) []byte {
+	var text bytes.Buffer
+	text.Grow(len(data))
+	for _, c := range data {
+		r := rune(c)
+		if high != nil && c >= 0x80 && c < 0xa0 {
+			r = high[c-0x80]
+		}
+		text.WriteRune(r)
+	}
+	return text.Bytes()
+}
+
+// encode8bit converts UTF-8 to a single byte encoding, the opposite of decode8bit. It fails on the first character that the encoding called name does not have.
+func encode8bit(text []byte, high *[32]rune, name string) ([]byte, error) {
+	data := make([]byte, 0, len(text))
+	for off := 0; off < len(text); {
+		r, n := utf8.DecodeRune(text[off:])
+		c, ok := byte(r), r < 0x100 && (high == nil || r < 0x80 || r >= 0xa0)
+		if high != nil && !ok {
+			for i, h := range high {
+				if h == r {
+					c, ok = byte(0x80+i), true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot encode %q at offset %d in %s", r, off, name)
+		}
+		data = append(data, c)
+		off += n
+	}
+	return data, nil
+}
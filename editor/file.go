@@ -1,13 +1,229 @@
 package editor
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrModified is returned when saving a file that has been changed on disk since it was read.
+	ErrModified = errors.New("file has been modified outside of poe")
+
+	// ErrNotRead is returned when saving over a file that exists but was never read, as when reading it failed.
+	ErrNotRead = errors.New("file exists but has not been read")
+
+	// ErrReadOnly is returned when saving a file that could not be written to when it was read.
+	ErrReadOnly = errors.New("file is read-only")
+)
+
 // file holds information about a file on disk.
 type file struct {
-	name   string
-	read   bool      // true if file has been read
-	mtime  time.Time // of file when last read/written
-	sha256 string    // of file when last read/written
+	name     string
+	read     bool      // true if file has been read
+	mtime    time.Time // of file when last read/written
+	sha256   string    // of file when last read/written
+	new      bool      // file did not exist when read
+	readonly bool      // file could not be written to when read
+	encoding string    // of file on disk, or UTF-8 if empty
+	crlf     bool      // lines end with \r\n on disk
+	bom      bool      // file starts with the byte order mark of its encoding
+	binary   bool      // file looked like binary data when read, and is kept byte for byte
+}
+
+var (
+	crlf = []byte("\r\n")
+	lf   = []byte("\n")
+)
+
+// detect finds the encoding, line endings and byte order mark of data read from the file. The encoding is guessed unless enc is given, and so is whether it is binary, in which case it is not converted at all. Lines end with \r\n if most of them do, so that a few stray line endings do not decide for the whole file.
+func (f *file) detect(data []byte, enc string) {
+	if enc == "" {
+		f.encoding, f.bom = detectEncoding(data)
+		f.binary = isBinary(data, f.encoding)
+	} else {
+		f.encoding = enc
+		f.bom = len(f.enc().bom) > 0 && bytes.HasPrefix(data, f.enc().bom)
+		f.binary = false
+	}
+	f.crlf = false
+	if e := f.enc(); !f.binary && e.valid != nil {
+		// a file that would not be written back as it was read is kept as bytes
+		body := data
+		if f.bom {
+			body = data[len(e.bom):]
+		}
+		f.binary = !e.valid(body)
+	}
+	if f.binary {
+		f.encoding, f.bom = "", false
+		return
+	}
+	text := f.decode(data)
+	n := bytes.Count(text, crlf)
+	f.crlf = n > 0 && n >= bytes.Count(text, lf)-n
+}
+
+// enc returns the encoding of the file.
+func (f *file) enc() *encoding {
+	if e, ok := encodings[f.encoding]; ok {
+		return e
+	}
+	return encodings[EncodingUTF8]
+}
+
+// decode returns data as it is kept in the buffer, in UTF-8 without byte order mark and with \n line endings, according to what was detected for the file.
+func (f *file) decode(data []byte) []byte {
+	if f.bom {
+		data = bytes.TrimPrefix(data, f.enc().bom)
+	}
+	data = f.enc().decode(data)
+	if f.crlf {
+		data = bytes.Replace(data, crlf, lf, -1)
+	}
+	return data
+}
+
+// encode returns text from the buffer as it is written to the file, the opposite of decode. It fails if the text has characters that the encoding of the file does not.
+func (f *file) encode(text []byte) ([]byte, error) {
+	if f.crlf {
+		text = bytes.Replace(text, lf, crlf, -1)
+	}
+	data, err := f.enc().encode(text)
+	if err != nil {
+		return nil, err
+	}
+	if f.bom {
+		data = append(append([]byte{}, f.enc().bom...), data...)
+	}
+	return data, nil
+}
+
+// writeFile replaces the content of the named file with what is read from r and returns the info of the new file.
+func writeFile(name string, r io.Reader) (os.FileInfo, error) {
+	name = resolveLink(name) // write the file a symbolic link points to
+
+	var perm os.FileMode = 0644 // for a new file, less what the umask takes away
+	old, err := os.Stat(name)
+	if err == nil {
+		perm = 0600 // until the mode of the old file is given to it
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	// renamed over the old file when synced, so that a crash leaves either the old or the new file
+	f, err := tempFile(dir, "."+base+".poe", perm)
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	fail := func(err error) (os.FileInfo, error) {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if old != nil && links(old) > 1 {
+		// renaming would leave the other links with the old content
+		err := overwrite(name, f)
+		f.Close()
+		os.Remove(tmp)
+		if err != nil {
+			return nil, err
+		}
+		return os.Stat(name)
+	}
+	if old != nil {
+		chown(f, old) // best effort, only the superuser may give away files
+		mode := old.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := f.Chmod(mode); err != nil { // after chown, which clears setuid and setgid
+			return fail(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	// sync the directory, so that the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return os.Stat(name)
+}
+
+// tempFile creates a new file in dir with a name starting with prefix, like ioutil.TempFile, but with the permissions perm less the umask.
+func tempFile(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := int64(0); ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatInt(time.Now().UnixNano()+i, 36))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// overwrite replaces the content of the named file with that of src, from its start.
+func overwrite(name string, src *os.File) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// mangle returns a name in dir for the file with the absolute path fn. The whole path is kept in the name, with separators replaced by %, so that files with the same name in different directories do not collide.
+func mangle(dir, fn string) string {
+	return filepath.Join(dir, strings.Replace(fn, string(filepath.Separator), "%", -1))
+}
+
+// resolveLink follows name as long as it is a symbolic link and returns the file it ends up at, which need not exist.
+func resolveLink(name string) string {
+	for i := 0; i < 255; i++ {
+		info, err := os.Lstat(name)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return name
+		}
+		link, err := os.Readlink(name)
+		if err != nil {
+			return name
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(name), link)
+		}
+		name = link
+	}
+	return name
 }
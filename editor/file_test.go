@@ -0,0 +1,753 @@
+package editor_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prodhe/poe/editor"
+)
+
+// tempFile creates a file with the given content in a new temporary directory and returns its name. The directory is removed by the returned function.
+func tempFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "poe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return fn, func() { os.RemoveAll(dir) }
+}
+
+// openFile reads fn into a new buffer.
+func openFile(t *testing.T, fn string) *editor.Buffer {
+	var buf editor.Buffer
+	buf.NewFile(fn)
+	if err := buf.ReadFile(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// changeFile writes content to fn and moves its modification time, so that the change is noticed regardless of timestamp resolution.
+func changeFile(t *testing.T, fn, content string) {
+	if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fn, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveModified(t *testing.T) {
+	fn, cleanup := tempFile(t, "one\ntwo\nthree\n")
+	defer cleanup()
+
+	buf := openFile(t, fn)
+	buf.SetDot(4, 7)
+	buf.Write([]byte("2"))
+
+	// touching the file does not count as a change
+	changeFile(t, fn, "one\ntwo\nthree\n")
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatalf("save after touch: %v", err)
+	}
+
+	changeFile(t, fn, "one\ntwo\nthree\nfour\n")
+	if _, err := buf.SaveFile(); err != editor.ErrModified {
+		t.Fatalf("save after outside change: expected %v, got %v", editor.ErrModified, err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("save after outside change: file was written: %q", b)
+	}
+
+	diff, err := buf.DiffFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "--- " + fn + "\n+++ " + fn + " (poe)\n" +
+		"@@ -1,4 +1,3 @@\n one\n-two\n+2\n three\n-four\n"
+	if diff != exp {
+		t.Errorf("diff: expected\n%s\ngot\n%s", exp, diff)
+	}
+
+	if _, err := buf.OverwriteFile(); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "one\n2\nthree\n" {
+		t.Errorf("overwrite: expected %q, got %q", "one\n2\nthree\n", b)
+	}
+	if diff, _ := buf.DiffFile(); diff != "" {
+		t.Errorf("diff after overwrite: expected none, got\n%s", diff)
+	}
+
+	buf.SeekDot(0, io.SeekEnd)
+	buf.Write([]byte("four\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Errorf("save after overwrite: %v", err)
+	}
+}
+
+func TestSaveFileMode(t *testing.T) {
+	fn, cleanup := tempFile(t, "#!/bin/sh\n")
+	defer cleanup()
+	if err := os.Chmod(fn, 0750|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(filepath.Dir(fn), "link")
+	if err := os.Symlink(filepath.Base(fn), link); err != nil {
+		t.Skip(err)
+	}
+
+	buf := openFile(t, link)
+	buf.SeekDot(0, io.SeekEnd)
+	buf.Write([]byte("echo hello\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(fn); string(b) != "#!/bin/sh\necho hello\n" {
+		t.Errorf("expected link target to be written, got %q", b)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected link to be kept, got %v %v", info.Mode(), err)
+	}
+	if info, _ := os.Stat(fn); info.Mode()&(os.ModePerm|os.ModeSetgid) != 0750|os.ModeSetgid {
+		t.Errorf("expected mode %v, got %v", 0750|os.ModeSetgid, info.Mode())
+	}
+	if names, _ := ioutil.ReadDir(filepath.Dir(fn)); len(names) != 2 {
+		t.Errorf("expected no temporary files left, got %d files", len(names))
+	}
+
+	// a file with hard links stays the same file
+	hard := filepath.Join(filepath.Dir(fn), "hard")
+	if err := os.Link(fn, hard); err != nil {
+		t.Skip(err)
+	}
+	buf.Write([]byte("echo again\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(hard); string(b) != "#!/bin/sh\necho hello\necho again\n" {
+		t.Errorf("expected hard link to be written, got %q", b)
+	}
+	a, _ := os.Stat(fn)
+	h, _ := os.Stat(hard)
+	if !os.SameFile(a, h) {
+		t.Errorf("expected hard link to be kept")
+	}
+	if names, _ := ioutil.ReadDir(filepath.Dir(fn)); len(names) != 3 {
+		t.Errorf("expected no temporary files left, got %d files", len(names))
+	}
+}
+
+func TestSaveBackup(t *testing.T) {
+	tests := []struct {
+		backup  string
+		dir     bool
+		keep    int
+		backups []string // content of backups after saving 1, 2 and 3, newest first
+	}{
+		{editor.BackupNone, false, 0, nil},
+		{editor.BackupSimple, false, 0, []string{"2"}},
+		{editor.BackupNumbered, false, 0, []string{"2", "1", "0"}},
+		{editor.BackupNumbered, false, 2, []string{"2", "1"}},
+		{editor.BackupNumbered, true, 0, []string{"2", "1", "0"}},
+	}
+	for _, tt := range tests {
+		fn, cleanup := tempFile(t, "0")
+		e := editor.New()
+		e.Settings().Backup = tt.backup
+		e.Settings().Backups = tt.keep
+		if tt.dir {
+			e.Settings().BackupDir = filepath.Join(filepath.Dir(fn), "backup")
+		}
+		_, buf := e.NewBuffer()
+		buf.NewFile(fn)
+		buf.ReadFile()
+		for _, s := range []string{"1", "2", "3"} {
+			buf.Replace(0, buf.Len(), []byte(s))
+			if _, err := buf.SaveFile(); err != nil {
+				t.Fatalf("%s: %v", tt.backup, err)
+			}
+		}
+
+		names, err := buf.Backups()
+		if err != nil {
+			t.Errorf("%s: %v", tt.backup, err)
+		}
+		var got []string
+		for _, name := range names {
+			if tt.dir != (filepath.Dir(name) == e.Settings().BackupDir) {
+				t.Errorf("%s: backup in wrong directory: %s", tt.backup, name)
+			}
+			b, _ := ioutil.ReadFile(name)
+			got = append(got, string(b))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.backups, ",") {
+			t.Errorf("%s keep %d: expected backups %q, got %q", tt.backup, tt.keep, tt.backups, got)
+		}
+
+		if len(names) > 0 {
+			_, old := e.NewBuffer()
+			old.NewFile(fn)
+			old.ReadFile()
+			if err := old.RestoreBackup(names[len(names)-1]); err != nil {
+				t.Fatal(err)
+			}
+			if err := old.Undo(); err != nil || old.String() != "3" {
+				t.Errorf("%s: undo restore: expected %q, got %q (%v)", tt.backup, "3", old.String(), err)
+			}
+			old.Redo()
+
+			// the file is not overwritten if it changed on disk in the meantime
+			changeFile(t, fn, "4")
+			if _, err := old.SaveFile(); err == nil {
+				t.Errorf("%s: restore: expected save over changed file to fail", tt.backup)
+			}
+			if _, err := old.OverwriteFile(); err != nil {
+				t.Fatal(err)
+			}
+			exp := tt.backups[len(tt.backups)-1]
+			if b, _ := ioutil.ReadFile(fn); string(b) != exp {
+				t.Errorf("%s: restore: expected %q, got %q", tt.backup, exp, b)
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestSwapRecover(t *testing.T) {
+	fn, cleanup := tempFile(t, "one\ntwo\n")
+	defer cleanup()
+
+	open := func() (editor.Editor, int64, *editor.Buffer) {
+		e := editor.New()
+		e.Settings().SwapDir = filepath.Join(filepath.Dir(fn), "swap")
+		id, buf := e.NewBuffer()
+		buf.NewFile(fn)
+		if err := buf.ReadFile(); err != nil {
+			t.Fatal(err)
+		}
+		return e, id, buf
+	}
+
+	// unchanged buffers have no recovery file
+	e, _, buf := open()
+	if err := e.WriteSwaps(); err != nil {
+		t.Fatal(err)
+	}
+	if name := buf.SwapFile(); name != "" {
+		t.Errorf("expected no recovery file, got %s", name)
+	}
+
+	// poe dies with unsaved changes
+	buf.SeekDot(0, io.SeekEnd)
+	buf.Write([]byte("three\n"))
+	if err := e.WriteSwaps(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, id, buf := open()
+	if buf.SwapFile() == "" {
+		t.Fatal("expected recovery file")
+	}
+	diff, err := buf.DiffSwap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(diff, "@@ -1,2 +1,3 @@\n one\n two\n+three\n") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+	if err := buf.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "one\ntwo\nthree\n" {
+		t.Errorf("recover: expected %q, got %q", "one\ntwo\nthree\n", got)
+	}
+	buf.Undo()
+	if got := buf.String(); got != "one\ntwo\n" {
+		t.Errorf("undo recover: expected %q, got %q", "one\ntwo\n", got)
+	}
+
+	// closing the buffer throws the changes away
+	e.CloseBuffer(id)
+	if _, _, buf = open(); buf.SwapFile() != "" {
+		t.Errorf("expected recovery file to be removed on close")
+	}
+
+	// and so does saving
+	e, _, buf = open()
+	buf.Write([]byte("zero\n"))
+	e.WriteSwaps()
+	buf.SaveFile()
+	if _, _, buf = open(); buf.SwapFile() != "" {
+		t.Errorf("expected recovery file to be removed on save")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	fn, cleanup := tempFile(t, "one\n")
+	defer cleanup()
+	dir := filepath.Dir(fn)
+
+	e := editor.New()
+	e.LoadBuffers([]string{fn, dir})
+	_, bufs := e.Buffers()
+	var file, list *editor.Buffer
+	for _, buf := range bufs {
+		if buf.IsDir() {
+			list = buf
+		} else {
+			file = buf
+		}
+	}
+
+	// a clean buffer is read again
+	changeFile(t, fn, "two\n")
+	if stale := e.Poll(); len(stale) != 0 {
+		t.Errorf("expected no stale buffers, got %d", len(stale))
+	}
+	if got := file.String(); got != "two\n" {
+		t.Errorf("poll clean: expected %q, got %q", "two\n", got)
+	}
+
+	// a directory is listed again
+	if err := ioutil.WriteFile(filepath.Join(dir, "new.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Hour)
+	os.Chtimes(dir, mtime, mtime)
+	e.Poll()
+	if got := list.String(); got != "file.txt\nnew.txt\n" {
+		t.Errorf("poll dir: expected %q, got %q", "file.txt\nnew.txt\n", got)
+	}
+
+	// a dirty buffer is marked stale, once
+	file.SeekDot(0, io.SeekEnd)
+	file.Write([]byte("three\n"))
+	changeFile(t, fn, "four\n")
+	if stale := e.Poll(); len(stale) != 1 || !file.Stale() {
+		t.Errorf("poll dirty: expected buffer to be stale")
+	}
+	if stale := e.Poll(); len(stale) != 0 {
+		t.Errorf("poll stale: expected no new stale buffers, got %d", len(stale))
+	}
+	if got := file.String(); got != "two\nthree\n" {
+		t.Errorf("poll dirty: expected %q, got %q", "two\nthree\n", got)
+	}
+	file.Reload()
+	if got := file.String(); file.Stale() || got != "four\n" {
+		t.Errorf("reload: expected %q, got %q (stale %v)", "four\n", got, file.Stale())
+	}
+}
+
+func TestReload(t *testing.T) {
+	fn, cleanup := tempFile(t, "one\ntwo\nthree\nfour\n")
+	defer cleanup()
+
+	buf := openFile(t, fn)
+	buf.SetDot(14, 18) // four
+	mark := int64(8)   // three
+	buf.Mark(&mark)
+	buf.SetDot(0, 0)
+	buf.Write([]byte("zero\n"))
+	buf.SetDot(19, 23)
+
+	changeFile(t, fn, "one\n1.5\nthree\nfour\nfive\n")
+	if err := buf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "one\n1.5\nthree\nfour\nfive\n" {
+		t.Fatalf("reload: expected %q, got %q", "one\n1.5\nthree\nfour\nfive\n", got)
+	}
+	if q0, q1 := buf.Dot(); buf.String()[q0:q1] != "four" {
+		t.Errorf("reload: expected dot to stay on %q, got %q", "four", buf.String()[q0:q1])
+	}
+	if got := buf.String()[mark:]; got != "three\nfour\nfive\n" {
+		t.Errorf("reload: expected mark to stay on %q, got %q", "three\n", got)
+	}
+	if buf.Dirty() {
+		t.Errorf("reload: expected buffer to be clean")
+	}
+
+	buf.Undo()
+	if got := buf.String(); got != "zero\none\ntwo\nthree\nfour\n" {
+		t.Errorf("undo reload: expected %q, got %q", "zero\none\ntwo\nthree\nfour\n", got)
+	}
+	if !buf.Dirty() {
+		t.Errorf("undo reload: expected buffer to be dirty")
+	}
+}
+
+func TestReloadLarge(t *testing.T) {
+	var text strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&text, "line %d %s\n", i, strings.Repeat("x", 40))
+	}
+	fn, cleanup := tempFile(t, text.String())
+	defer cleanup()
+
+	buf := openFile(t, fn)
+	changed := strings.Replace(text.String(), "line 50000 ", "line fifty thousand ", 1)
+	changeFile(t, fn, changed)
+
+	start := time.Now()
+	if diff, err := buf.DiffFile(); err != nil || !strings.Contains(diff, "fifty thousand") {
+		t.Errorf("diff: expected the changed line, got %q (%v)", diff, err)
+	}
+	if err := buf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != changed {
+		t.Errorf("reload: expected the changed file")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected a diff and reload of %d bytes to take well under 5s, took %s", len(changed), d)
+	}
+}
+
+func TestSaveAs(t *testing.T) {
+	fn, cleanup := tempFile(t, "hello\n")
+	defer cleanup()
+	newfn := filepath.Join(filepath.Dir(fn), "new.txt")
+
+	buf := openFile(t, fn)
+	buf.SeekDot(0, io.SeekEnd)
+	buf.Write([]byte("world\n"))
+
+	if _, err := buf.SaveAs(fn); err == nil {
+		t.Errorf("save as existing file: expected error")
+	}
+	if _, err := buf.SaveAs(newfn); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Name(); got != newfn {
+		t.Errorf("expected name %q, got %q", newfn, got)
+	}
+	if buf.Dirty() {
+		t.Errorf("expected buffer to be clean")
+	}
+
+	buf.Write([]byte("again\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(newfn); string(b) != "hello\nworld\nagain\n" {
+		t.Errorf("expected new file %q, got %q", "hello\nworld\nagain\n", b)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "hello\n" {
+		t.Errorf("expected old file to be left alone, got %q", b)
+	}
+}
+
+func TestOpenNew(t *testing.T) {
+	fn, cleanup := tempFile(t, "")
+	defer cleanup()
+	fn = filepath.Join(filepath.Dir(fn), "typo.go")
+
+	buf := openFile(t, fn)
+	if !buf.IsNew() || buf.Dirty() || buf.Len() != 0 {
+		t.Errorf("expected new, clean and empty buffer, got new %v dirty %v len %d", buf.IsNew(), buf.Dirty(), buf.Len())
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be created on open, got %v", err)
+	}
+
+	buf.Write([]byte("package main\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "package main\n" || buf.IsNew() {
+		t.Errorf("expected file to be created on save, got %q (new %v)", b, buf.IsNew())
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("everything is writable to root")
+	}
+	fn, cleanup := tempFile(t, "hello\n")
+	defer cleanup()
+	if err := os.Chmod(fn, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := openFile(t, fn)
+	if got := buf.String(); got != "hello\n" || !buf.ReadOnly() {
+		t.Fatalf("expected read-only buffer with %q, got %q (read-only %v)", "hello\n", got, buf.ReadOnly())
+	}
+	buf.Write([]byte("world\n"))
+	if _, err := buf.SaveFile(); err != editor.ErrReadOnly {
+		t.Errorf("save read-only: expected %v, got %v", editor.ErrReadOnly, err)
+	}
+}
+
+func TestSaveUnread(t *testing.T) {
+	fn, cleanup := tempFile(t, "hello\n")
+	defer cleanup()
+
+	// a file that could not be read is not saved over
+	_, buf := editor.New().NewBuffer()
+	buf.NewFile(fn)
+	if os.Geteuid() != 0 {
+		os.Chmod(fn, 0)
+		if err := buf.ReadFile(); err == nil {
+			t.Errorf("expected error reading unreadable file")
+		}
+	}
+	buf.Write([]byte("world\n"))
+	if _, err := buf.SaveFile(); err != editor.ErrNotRead {
+		t.Errorf("save: expected %v, got %v", editor.ErrNotRead, err)
+	}
+	if _, err := buf.OverwriteFile(); err != editor.ErrNotRead {
+		t.Errorf("overwrite: expected %v, got %v", editor.ErrNotRead, err)
+	}
+	os.Chmod(fn, 0644)
+	if b, _ := ioutil.ReadFile(fn); string(b) != "hello\n" {
+		t.Errorf("expected file to be left alone, got %q", b)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	var tt = []struct {
+		name      string
+		disk      string
+		text      string
+		crlf, bom bool
+		saved     string // after adding a line
+	}{
+		{"lf", "a\nb\n", "a\nb\n", false, false, "a\nb\nz\n"},
+		{"crlf", "a\r\nb\r\n", "a\nb\n", true, false, "a\r\nb\r\nz\r\n"},
+		{"mostly crlf", "a\r\nb\r\nc\n", "a\nb\nc\n", true, false, "a\r\nb\r\nc\r\nz\r\n"},
+		{"mostly lf", "a\nb\nc\r\n", "a\nb\nc\r\n", false, false, "a\nb\nc\r\nz\n"},
+		{"bom", "\xef\xbb\xbfa\n", "a\n", false, true, "\xef\xbb\xbfa\nz\n"},
+		{"bom and crlf", "\xef\xbb\xbfa\r\n", "a\n", true, true, "\xef\xbb\xbfa\r\nz\r\n"},
+	}
+
+	for _, tc := range tt {
+		fn, cleanup := tempFile(t, tc.disk)
+		buf := openFile(t, fn)
+		if got := buf.String(); got != tc.text || buf.CRLF() != tc.crlf || buf.BOM() != tc.bom {
+			t.Errorf("%s: expected %q (crlf %v bom %v), got %q (crlf %v bom %v)", tc.name, tc.text, tc.crlf, tc.bom, got, buf.CRLF(), buf.BOM())
+		}
+		if diff, _ := buf.DiffFile(); diff != "" {
+			t.Errorf("%s: expected no diff, got %q", tc.name, diff)
+		}
+		buf.SeekDot(0, io.SeekEnd)
+		buf.Write([]byte("z\n"))
+		if _, err := buf.SaveFile(); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(fn); string(b) != tc.saved {
+			t.Errorf("%s: expected %q on disk, got %q", tc.name, tc.saved, b)
+		}
+		cleanup()
+	}
+
+	// converting the line endings changes the buffer
+	fn, cleanup := tempFile(t, "a\r\nb\r\n")
+	defer cleanup()
+	buf := openFile(t, fn)
+	buf.SetCRLF(false)
+	if !buf.Dirty() {
+		t.Errorf("expected buffer to be dirty after changing line endings")
+	}
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "a\nb\n" {
+		t.Errorf("expected %q on disk, got %q", "a\nb\n", b)
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	var tt = []struct {
+		name string
+		disk string
+		text string
+		enc  string
+	}{
+		{"utf-8", "caf\xc3\xa9\n", "café\n", editor.EncodingUTF8},
+		{"latin1", "caf\xe9\n", "café\n", editor.EncodingLatin1},
+		{"windows-1252", "\x80 5\n", "€ 5\n", editor.EncodingWindows1252},
+		{"utf-16le bom", "\xff\xfeh\x00\xe9\x00\n\x00", "hé\n", editor.EncodingUTF16LE},
+		{"utf-16be bom", "\xfe\xff\x00h\x00\xe9\x00\n", "hé\n", editor.EncodingUTF16BE},
+		{"utf-16le", "h\x00\xe9\x00\r\x00\n\x00", "hé\n", editor.EncodingUTF16LE},
+		{"surrogates", "\xff\xfe\x3d\xd8\x00\xde", "😀", editor.EncodingUTF16LE},
+	}
+
+	for _, tc := range tt {
+		fn, cleanup := tempFile(t, tc.disk)
+		buf := openFile(t, fn)
+		if got := buf.String(); got != tc.text || buf.Encoding() != tc.enc {
+			t.Errorf("%s: expected %q in %s, got %q in %s", tc.name, tc.text, tc.enc, got, buf.Encoding())
+		}
+		if _, err := buf.OverwriteFile(); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(fn); string(b) != tc.disk {
+			t.Errorf("%s: expected %q on disk after save, got %q", tc.name, tc.disk, b)
+		}
+		cleanup()
+	}
+
+	fn, cleanup := tempFile(t, "caf\xc3\xa9\n")
+	defer cleanup()
+
+	// an explicit encoding is not guessed
+	e := editor.New()
+	e.Settings().Encoding = editor.EncodingLatin1
+	_, buf := e.NewBuffer()
+	buf.NewFile(fn)
+	if err := buf.ReadFile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "cafÃ©\n" {
+		t.Errorf("read as latin1: expected %q, got %q", "cafÃ©\n", got)
+	}
+
+	// converting
+	buf = openFile(t, fn)
+	if err := buf.SetEncoding("ISO-8859-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "caf\xe9\n" {
+		t.Errorf("convert to latin1: expected %q, got %q", "caf\xe9\n", b)
+	}
+	buf.Write([]byte("€"))
+	if _, err := buf.SaveFile(); err == nil {
+		t.Errorf("expected error saving € in latin1")
+	}
+	if err := buf.SetEncoding("klingon"); err == nil {
+		t.Errorf("expected error on unknown encoding")
+	}
+}
+
+func TestOpenBinary(t *testing.T) {
+	fn, cleanup := tempFile(t, "\x7fELF\x00\xe2\x82\n")
+	defer cleanup()
+
+	buf := openFile(t, fn)
+	if !buf.Binary() || !buf.ReadOnly() || buf.String() != "\x7fELF\x00\xe2\x82\n" {
+		t.Fatalf("expected read-only binary buffer with the bytes of the file, got binary %v read-only %v %q", buf.Binary(), buf.ReadOnly(), buf.String())
+	}
+
+	// the dot is not moved to the start of a rune
+	if q0, _, _ := buf.SetDot(7, 7); q0 != 7 {
+		t.Errorf("expected dot at 7, got %d", q0)
+	}
+
+	buf.Replace(6, 7, []byte{0xff})
+	if _, err := buf.SaveFile(); err != editor.ErrReadOnly {
+		t.Errorf("save binary: expected %v, got %v", editor.ErrReadOnly, err)
+	}
+	buf.SetReadOnly(false)
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "\x7fELF\x00\xe2\xff\n" {
+		t.Errorf("expected %q on disk, got %q", "\x7fELF\x00\xe2\xff\n", b)
+	}
+
+	// files that would not be saved as they were read as text are binary too
+	for _, disk := range []string{
+		"\xff\xfe\x3d\xd8a\x00",            // unpaired surrogate
+		"\xfe\xff\x00h\x00i\x00",           // trailing odd byte
+		"\x01\x00\x82\x00\x03\x00\xff\x00", // zero every other byte, but not text
+	} {
+		fn, cleanup := tempFile(t, disk)
+		buf := openFile(t, fn)
+		if !buf.Binary() || !buf.ReadOnly() || buf.String() != disk {
+			t.Errorf("%q: expected read-only binary buffer, got binary %v read-only %v %q", disk, buf.Binary(), buf.ReadOnly(), buf.String())
+		}
+		cleanup()
+	}
+}
+
+func TestOpenLarge(t *testing.T) {
+	fn, cleanup := tempFile(t, "one\ntwo\nthree\n")
+	defer cleanup()
+
+	e := editor.New()
+	e.Settings().LargeFile = 8
+	_, buf := e.NewBuffer()
+	buf.NewFile(fn)
+	if err := buf.ReadFile(); err != nil {
+		t.Fatal(err)
+	}
+	if !buf.Paged() || buf.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("expected paged buffer with the text of the file, got paged %v %q", buf.Paged(), buf.String())
+	}
+	if line, col := buf.OffsetToLineCol(9); line != 2 || col != 1 {
+		t.Errorf("expected line 2 col 1, got line %d col %d", line, col)
+	}
+	if n := buf.NextDelim('\n', 5); n != 2 {
+		t.Errorf("expected 2 bytes to the end of the line, got %d", n)
+	}
+	if n := buf.PrevDelim('\n', 9); n != 2 {
+		t.Errorf("expected 2 bytes back to the newline, got %d", n)
+	}
+	if n := buf.PrevDelim('\n', 2); n != 2 {
+		t.Errorf("expected 2 bytes back to the start, got %d", n)
+	}
+	if n := buf.NextDelim('\n', 14); n != 0 {
+		t.Errorf("expected nothing at the end of the text, got %d", n)
+	}
+	if q0, q1, err := buf.Address("3"); err != nil || q0 != 8 || q1 != 14 {
+		t.Errorf("expected line 3 at 8,14, got %d,%d (%v)", q0, q1, err)
+	}
+
+	buf.SetDot(4, 7)
+	buf.Write([]byte("2"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "one\n2\nthree\n" {
+		t.Errorf("expected %q on disk, got %q", "one\n2\nthree\n", b)
+	}
+	if err := buf.Undo(); err != nil || buf.String() != "one\ntwo\nthree\n" {
+		t.Errorf("expected undo after saving, got %q (%v)", buf.String(), err)
+	}
+	buf.Redo()
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a paged file is opened again when it changes
+	changeFile(t, fn, "one\n2\nthree\nfour\n")
+	if _, err := buf.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if !buf.Paged() || buf.String() != "one\n2\nthree\nfour\n" {
+		t.Errorf("expected paged buffer with the new text, got paged %v %q", buf.Paged(), buf.String())
+	}
+
+	// and read into memory if it has to be converted
+	changeFile(t, fn, "one\r\ntwo\r\nthree\r\n")
+	if err := buf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Paged() || !buf.CRLF() || buf.String() != "one\ntwo\nthree\n" {
+		t.Errorf("expected crlf buffer in memory, got paged %v crlf %v %q", buf.Paged(), buf.CRLF(), buf.String())
+	}
+
+	_, small := e.NewBuffer()
+	small.NewFile(filepath.Join(filepath.Dir(fn), "small.txt"))
+	ioutil.WriteFile(small.Name(), []byte("one\n"), 0644)
+	if small.ReadFile(); small.Paged() {
+		t.Errorf("expected a file smaller than the limit in memory")
+	}
+}
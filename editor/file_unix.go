@@ -0,0 +1,32 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package editor
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown gives f the owner and group of the file described by info.
+func chown(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
+
+// links returns the number of hard links to the file described by info.
+func links(info os.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(st.Nlink)
+}
+
+// writable returns true if the user may write to the named file.
+func writable(name string) bool {
+	return syscall.Access(name, 2) == nil // W_OK
+}
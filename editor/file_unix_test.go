@@ -0,0 +1,31 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package editor_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestSaveUmask(t *testing.T) {
+	fn, cleanup := tempFile(t, "")
+	defer cleanup()
+	fn = filepath.Join(filepath.Dir(fn), "new.txt")
+
+	defer syscall.Umask(syscall.Umask(077))
+	buf := openFile(t, fn)
+	buf.Write([]byte("secret\n"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected new file with mode %v from the umask, got %v", os.FileMode(0600), info.Mode().Perm())
+	}
+}
@@ -0,0 +1,287 @@
+package editor
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type HistoryAction uint8
+
+const (
+	HInsert HistoryAction = iota
+	HDelete
+)
+
+type Change struct {
+	offset  int64
+	action  HistoryAction
+	content []byte
+	time    time.Time // when the change was stored in history
+}
+
+// ChangeSet is a group of changes that are undone and redone as one.
+type ChangeSet []*Change
+
+// span returns the range of text covered by the changes in cs, after they have been applied in order.
+func (cs ChangeSet) span() (q0, q1 int64) {
+	for i, c := range cs {
+		n := int64(len(c.content))
+		if c.action == HInsert {
+			if i == 0 {
+				q0, q1 = c.offset, c.offset+n
+				continue
+			}
+			if c.offset <= q0 {
+				q0 += n
+			}
+			if c.offset <= q1 {
+				q1 += n
+			}
+			if c.offset < q0 {
+				q0 = c.offset
+			}
+			if c.offset+n > q1 {
+				q1 = c.offset + n
+			}
+			continue
+		}
+		if i == 0 {
+			q0, q1 = c.offset, c.offset
+			continue
+		}
+		q0, q1 = deleted(q0, c.offset, n), deleted(q1, c.offset, n)
+		if c.offset < q0 {
+			q0 = c.offset
+		}
+		if c.offset > q1 {
+			q1 = c.offset
+		}
+	}
+	return q0, q1
+}
+
+// inserted returns where offset q ends up after inserting n bytes at offset. Text inserted right at q ends up after it, so that q stays put while typing there.
+func inserted(q, offset, n int64) int64 {
+	if q > offset {
+		return q + n
+	}
+	return q
+}
+
+// deleted returns where offset q ends up after deleting n bytes at offset.
+func deleted(q, offset, n int64) int64 {
+	switch {
+	case q <= offset:
+		return q
+	case q < offset+n:
+		return offset
+	default:
+		return q - n
+	}
+}
+
+// state is a node in the undo tree. It is reached from its parent by applying its change set.
+type state struct {
+	cs       ChangeSet
+	parent   *state
+	children []*state // branches, oldest first
+	redo     int      // index of the child followed by Redo
+	level    int      // distance from the root
+	seq      int      // order of creation, where the root is 0
+	time     time.Time
+}
+
+// History is an undo tree. Every change set leads to a new state, and undoing back to an earlier state and changing something from there starts a new branch instead of throwing the undone states away.
+type History struct {
+	cur    *state
+	states []*state // all states in order of creation
+	depth  int      // number of nested Begin
+	fresh  bool     // next change starts a new set, even when grouping
+	typing bool     // the current set was typed and can be continued
+}
+
+// init creates the root state of a zero History.
+func (h *History) init() {
+	if h.cur == nil {
+		h.cur = &state{time: time.Now()}
+		h.states = []*state{h.cur}
+	}
+}
+
+// Do stores c in history. It starts a new change set, unless a group has been started with Begin.
+func (h *History) Do(c Change) {
+	h.init()
+	c.time = time.Now()
+	if h.depth > 0 && !h.fresh && h.cur.parent != nil && len(h.cur.children) == 0 {
+		h.cur.cs = append(h.cur.cs, &c)
+		h.cur.time = c.time
+	} else {
+		s := &state{
+			cs:     ChangeSet{&c},
+			parent: h.cur,
+			level:  h.cur.level + 1,
+			seq:    len(h.states),
+			time:   c.time,
+		}
+		h.cur.children = append(h.cur.children, s)
+		h.cur.redo = len(h.cur.children) - 1
+		h.states = append(h.states, s)
+		h.cur = s
+	}
+	h.fresh = false
+	h.typing = false
+}
+
+// Type stores c in history like Do. If the last change set was typed as well and c continues right where it left off, c is added to it. A typed newline ends the set.
+func (h *History) Type(c Change) {
+	h.init()
+	if h.typing && h.depth == 0 && h.cur.parent != nil && len(h.cur.children) == 0 {
+		cs := h.cur.cs
+		last := cs[len(cs)-1]
+		if last.action == c.action &&
+			(c.action == HInsert && last.offset+int64(len(last.content)) == c.offset ||
+				c.action == HDelete && c.offset+int64(len(c.content)) == last.offset) {
+			c.time = time.Now()
+			h.cur.cs = append(cs, &c)
+			h.cur.time = c.time
+			h.typing = c.action == HDelete || !bytes.Contains(c.content, []byte{'\n'})
+			return
+		}
+	}
+	h.Do(c)
+	h.typing = c.action == HDelete || !bytes.Contains(c.content, []byte{'\n'})
+}
+
+// Begin starts a group, so that all changes until the matching End are stored in the same change set. Groups may be nested, in which case the outermost one decides.
+func (h *History) Begin() {
+	if h.depth == 0 {
+		h.fresh = true
+	}
+	h.depth++
+}
+
+// End ends the group started by Begin.
+func (h *History) End() {
+	if h.depth > 0 {
+		h.depth--
+	}
+}
+
+// Undo moves to the parent of the current state and returns the changes to apply to get there.
+func (h *History) Undo() (ChangeSet, error) {
+	h.init()
+	if h.cur.parent == nil {
+		return nil, errors.New("no history")
+	}
+	return h.travel(h.cur.parent), nil
+}
+
+// Redo moves to the child of the current state that was last visited, or else the newest one, and returns the changes to apply to get there.
+func (h *History) Redo() (ChangeSet, error) {
+	h.init()
+	if len(h.cur.children) == 0 {
+		return nil, errors.New("no recall history")
+	}
+	return h.travel(h.cur.children[h.cur.redo]), nil
+}
+
+// Branch moves n siblings away from the current state, wrapping around, and returns the changes to apply to get there. Siblings are ordered from oldest to newest, so a negative n moves to older branches.
+func (h *History) Branch(n int) (ChangeSet, error) {
+	h.init()
+	if h.cur.parent == nil || len(h.cur.parent.children) < 2 {
+		return nil, errors.New("no other branch")
+	}
+	sib := h.cur.parent.children
+	i := 0
+	for sib[i] != h.cur {
+		i++
+	}
+	i = (i + n%len(sib) + len(sib)) % len(sib)
+	return h.travel(sib[i]), nil
+}
+
+// Step moves n states forwards or backwards in the order they were created, regardless of branch, and returns the changes to apply to get there.
+func (h *History) Step(n int) (ChangeSet, error) {
+	h.init()
+	i := h.cur.seq + n
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(h.states) {
+		i = len(h.states) - 1
+	}
+	if i == h.cur.seq {
+		return nil, errors.New("no history")
+	}
+	return h.travel(h.states[i]), nil
+}
+
+// Travel moves to the state that was created last at or before t, and returns the changes to apply to get there.
+func (h *History) Travel(t time.Time) (ChangeSet, error) {
+	h.init()
+	i := len(h.states) - 1
+	for i > 0 && h.states[i].time.After(t) {
+		i--
+	}
+	if h.states[i] == h.cur {
+		return nil, errors.New("no history")
+	}
+	return h.travel(h.states[i]), nil
+}
+
+// Time returns the time of the last change leading to the current state, or the time history was started for the state before any changes.
+func (h *History) Time() time.Time {
+	h.init()
+	return h.cur.time
+}
+
+// travel moves from the current state to s, through their closest common ancestor, and returns the changes on the way.
+func (h *History) travel(s *state) ChangeSet {
+	var cs ChangeSet
+	var path []*state // from s back to the common ancestor
+	from, to := h.cur, s
+	for from.level > to.level {
+		cs = append(cs, invert(from.cs)...)
+		from = from.parent
+	}
+	for to.level > from.level {
+		path = append(path, to)
+		to = to.parent
+	}
+	for from != to {
+		cs = append(cs, invert(from.cs)...)
+		from = from.parent
+		path = append(path, to)
+		to = to.parent
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		p := path[i]
+		cs = append(cs, p.cs...)
+		for k, c := range p.parent.children {
+			if c == p {
+				p.parent.redo = k
+			}
+		}
+	}
+	h.cur = s
+	h.typing = false
+	return cs
+}
+
+// invert returns the changes that undo cs, in the order to apply them.
+func invert(cs ChangeSet) ChangeSet {
+	r := make(ChangeSet, len(cs))
+	for i, c := range cs {
+		u := *c
+		switch u.action {
+		case HInsert:
+			u.action = HDelete
+		case HDelete:
+			u.action = HInsert
+		}
+		r[len(r)-1-i] = &u
+	}
+	return r
+}
@@ -0,0 +1,235 @@
+package editor_test
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prodhe/poe/editor"
+)
+
+func TestUndoTyping(t *testing.T) {
+	var buf editor.Buffer
+	for _, r := range "hello\nworld" {
+		buf.Write([]byte(string(r)))
+	}
+
+	buf.Undo()
+	if got := buf.String(); got != "hello\n" {
+		t.Errorf("undo typing: expected %q, got %q", "hello\n", got)
+	}
+	buf.Undo()
+	if got := buf.String(); got != "" {
+		t.Errorf("undo typing: expected %q, got %q", "", got)
+	}
+	buf.Redo()
+	buf.Redo()
+	if got := buf.String(); got != "hello\nworld" {
+		t.Errorf("redo typing: expected %q, got %q", "hello\nworld", got)
+	}
+
+	// moving the cursor starts a new change set
+	buf.SetDot(5, 5)
+	buf.Write([]byte("!"))
+	buf.SetDot(0, 0)
+	buf.Write([]byte("¡"))
+	buf.Undo()
+	if got := buf.String(); got != "hello!\nworld" {
+		t.Errorf("undo after move: expected %q, got %q", "hello!\nworld", got)
+	}
+}
+
+func TestUndoDelete(t *testing.T) {
+	var buf editor.Buffer
+	buf.Write([]byte("hello world"))
+	for i := 0; i < 5; i++ {
+		buf.Delete()
+	}
+	if got := buf.String(); got != "hello " {
+		t.Fatalf("delete: expected %q, got %q", "hello ", got)
+	}
+	buf.Undo()
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("undo deletes: expected %q, got %q", "hello world", got)
+	}
+	if q0, q1 := buf.Dot(); q0 != 6 || q1 != 11 {
+		t.Errorf("undo deletes: expected dot 6,11, got %d,%d", q0, q1)
+	}
+}
+
+func TestUndoReplace(t *testing.T) {
+	var buf editor.Buffer
+	buf.Write([]byte("hello world"))
+	buf.SetDot(6, 11)
+	buf.Write([]byte("gopher"))
+	if got := buf.String(); got != "hello gopher" {
+		t.Fatalf("replace: expected %q, got %q", "hello gopher", got)
+	}
+	buf.Undo()
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("undo replace: expected %q, got %q", "hello world", got)
+	}
+	if q0, q1 := buf.Dot(); q0 != 6 || q1 != 11 {
+		t.Errorf("undo replace: expected dot 6,11, got %d,%d", q0, q1)
+	}
+}
+
+func TestUndoEdit(t *testing.T) {
+	e := editor.New()
+	id, buf := e.NewBuffer()
+	buf.Write([]byte("a b a b"))
+	e.Edit(id, ",x/a/ c/xyz/ ,x/b/d")
+	if got := buf.String(); got != "xyz  xyz " {
+		t.Fatalf("edit: expected %q, got %q", "xyz  xyz ", got)
+	}
+	buf.Undo()
+	if got := buf.String(); got != "a b a b" {
+		t.Errorf("undo edit: expected %q, got %q", "a b a b", got)
+	}
+	buf.Redo()
+	if got := buf.String(); got != "xyz  xyz " {
+		t.Errorf("redo edit: expected %q, got %q", "xyz  xyz ", got)
+	}
+}
+
+func TestUndoBranch(t *testing.T) {
+	var buf editor.Buffer
+	buf.Write([]byte("hello"))
+	buf.Write([]byte(" world"))
+	buf.Undo()
+	buf.Write([]byte(" gopher"))
+	if got := buf.String(); got != "hello gopher" {
+		t.Fatalf("new branch: expected %q, got %q", "hello gopher", got)
+	}
+
+	// the undone branch is kept
+	if err := buf.Branch(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("branch: expected %q, got %q", "hello world", got)
+	}
+	buf.Branch(-1)
+	if got := buf.String(); got != "hello gopher" {
+		t.Errorf("branch back: expected %q, got %q", "hello gopher", got)
+	}
+
+	// redo follows the branch last visited
+	buf.Branch(1)
+	buf.Undo()
+	buf.Redo()
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("redo branch: expected %q, got %q", "hello world", got)
+	}
+
+	// steps are in order of creation, across branches
+	tests := []struct {
+		n   int
+		exp string
+	}{
+		{1, "hello gopher"},
+		{-1, "hello world"},
+		{-2, ""},
+		{3, "hello gopher"},
+	}
+	for _, tt := range tests {
+		buf.Step(tt.n)
+		if got := buf.String(); got != tt.exp {
+			t.Errorf("step %d: expected %q, got %q", tt.n, tt.exp, got)
+		}
+	}
+	if err := buf.Step(1); err == nil {
+		t.Errorf("step past newest: expected error")
+	}
+}
+
+func TestUndoEarlier(t *testing.T) {
+	var buf editor.Buffer
+	buf.Write([]byte("one"))
+	time.Sleep(20 * time.Millisecond)
+	buf.Write([]byte(" two"))
+	time.Sleep(20 * time.Millisecond)
+	buf.Write([]byte(" three"))
+
+	buf.Earlier(10 * time.Millisecond)
+	if got := buf.String(); got != "one two" {
+		t.Errorf("earlier: expected %q, got %q", "one two", got)
+	}
+	buf.Later(time.Hour)
+
+	// earlier counts from now, not from the last change
+	time.Sleep(30 * time.Millisecond)
+	buf.Earlier(10 * time.Millisecond)
+	if got := buf.String(); got != "one two three" {
+		t.Errorf("earlier after a while: expected %q, got %q", "one two three", got)
+	}
+	buf.Earlier(time.Hour)
+	if got := buf.String(); got != "" {
+		t.Errorf("earlier: expected %q, got %q", "", got)
+	}
+	if err := buf.Earlier(time.Hour); err == nil {
+		t.Errorf("earlier than first change: expected error")
+	}
+	buf.Later(time.Hour)
+	if got := buf.String(); got != "one two three" {
+		t.Errorf("later: expected %q, got %q", "one two three", got)
+	}
+}
+
+func TestUndoJournal(t *testing.T) {
+	fn, cleanup := tempFile(t, "hello")
+	defer cleanup()
+
+	open := func() (editor.Editor, int64, *editor.Buffer) {
+		e := editor.New()
+		e.Settings().UndoDir = filepath.Join(filepath.Dir(fn), "undo")
+		id, buf := e.NewBuffer()
+		buf.NewFile(fn)
+		if err := buf.ReadFile(); err != nil {
+			t.Fatal(err)
+		}
+		return e, id, buf
+	}
+
+	e, id, buf := open()
+	buf.SeekDot(0, io.SeekEnd)
+	buf.Write([]byte(" world"))
+	if _, err := buf.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Undo()
+	buf.Write([]byte(" gopher"))
+	buf.Branch(-1)
+	e.CloseBuffer(id) // same text as on disk, so the journal is updated
+
+	e, id, buf = open()
+	buf.Write([]byte("!"))
+	e.CloseBuffer(id) // changed since save, so the journal is not updated
+
+	_, _, buf = open()
+	if err := buf.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "hello" {
+		t.Errorf("undo after reopen: expected %q, got %q", "hello", got)
+	}
+	buf.Redo()
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("redo after reopen: expected %q, got %q", "hello world", got)
+	}
+	buf.Branch(1)
+	if got := buf.String(); got != "hello gopher" {
+		t.Errorf("branch after reopen: expected %q, got %q", "hello gopher", got)
+	}
+
+	// the journal does not apply to a file changed by someone else
+	if err := ioutil.WriteFile(fn, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, buf = open()
+	if err := buf.Undo(); err == nil {
+		t.Errorf("undo after outside change: expected error, got %q", buf.String())
+	}
+}
@@ -0,0 +1,162 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Job is an external command running in the background. It is started by Edit and reports back through JobEvents.
+type Job struct {
+	ID    int
+	Cmd   string // command line as given by the user
+	BufID int64  // buffer the job was started from
+	Dir   string // directory the job runs in
+	Start time.Time
+
+	op     byte  // one of !, <, > and |
+	q0, q1 int64 // dot to replace for < and |, which follows edits while the job runs
+	proc   *exec.Cmd
+	out    bytes.Buffer // collected output for < and |
+}
+
+// Name returns the first word of the command line.
+func (j *Job) Name() string {
+	if f := strings.Fields(j.Cmd); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
+
+// JobEvent is sent when a job writes output or exits.
+type JobEvent struct {
+	Job    *Job
+	Output []byte
+	Done   bool  // the job has exited
+	Err    error // exit error when Done
+}
+
+// jobWriter is the standard output or error of a job. Output is sent as events or, if it is standard output of a job that replaces text, collected until it exits.
+type jobWriter struct {
+	job    *Job
+	stderr bool
+	events chan<- JobEvent
+}
+
+func (w *jobWriter) Write(p []byte) (int, error) {
+	if !w.stderr && (w.job.op == '<' || w.job.op == '|') {
+		return w.job.out.Write(p)
+	}
+	out := make([]byte, len(p))
+	copy(out, p)
+	w.events <- JobEvent{Job: w.job, Output: out}
+	return len(p), nil
+}
+
+// start runs command as a job in the background. The dot of buf is the input for > and |, and is replaced when the job exits for < and |.
+func (e *ed) start(bufid int64, op byte, command string) error {
+	buf := e.buffers[bufid]
+	q0, q1 := buf.Dot()
+	c := e.command(bufid, q0, q1, command)
+
+	e.lastjob++
+	j := &Job{
+		ID:    e.lastjob,
+		Cmd:   command,
+		BufID: bufid,
+		Dir:   c.Dir,
+		Start: time.Now(),
+		op:    op,
+		proc:  c,
+	}
+	j.q0, j.q1 = q0, q1
+	if op == '>' || op == '|' {
+		c.Stdin = strings.NewReader(buf.ReadDot())
+	}
+	if op == '<' || op == '|' {
+		// the dot moves along with edits made while the job runs
+		buf.Mark(&j.q0)
+		buf.Mark(&j.q1)
+	}
+	c.Stdout = &jobWriter{job: j, events: e.events}
+	c.Stderr = &jobWriter{job: j, stderr: true, events: e.events}
+
+	if err := c.Start(); err != nil {
+		buf.Unmark(&j.q0)
+		buf.Unmark(&j.q1)
+		return err
+	}
+	e.jobs[j.ID] = j
+
+	go func() {
+		err := c.Wait()
+		e.events <- JobEvent{Job: j, Done: true, Err: err}
+	}()
+
+	return nil
+}
+
+// Jobs returns the running jobs, oldest first.
+func (e *ed) Jobs() []*Job {
+	js := make([]*Job, 0, len(e.jobs))
+	for _, j := range e.jobs {
+		js = append(js, j)
+	}
+	sort.Slice(js, func(i, k int) bool { return js[i].ID < js[k].ID })
+	return js
+}
+
+// Kill terminates the jobs with the given id or name. An empty name kills all jobs.
+func (e *ed) Kill(name string) error {
+	id, _ := strconv.Atoi(name)
+	var n int
+	for _, j := range e.jobs {
+		if name == "" || j.ID == id || j.Name() == name {
+			kill(j.proc)
+			n++
+		}
+	}
+	if n == 0 && name != "" {
+		return fmt.Errorf("no such job: %s", name)
+	}
+	return nil
+}
+
+// JobEvents returns the channel of events from running jobs. Each event must be passed on to HandleJobEvent by the goroutine operating on the buffers.
+func (e *ed) JobEvents() <-chan JobEvent {
+	return e.events
+}
+
+// HandleJobEvent takes care of an event from a job and returns any output. When a job has exited it is removed, and for < and | its output replaces the dot it was started from.
+func (e *ed) HandleJobEvent(ev JobEvent) string {
+	j := ev.Job
+	if !ev.Done {
+		return string(ev.Output)
+	}
+
+	delete(e.jobs, j.ID)
+	buf, ok := e.buffers[j.BufID]
+	if ok {
+		buf.Unmark(&j.q0)
+		buf.Unmark(&j.q1)
+	}
+
+	if ev.Err != nil {
+		return fmt.Sprintf("%s: %s\n", j.Cmd, ev.Err)
+	}
+
+	if j.op == '<' || j.op == '|' {
+		if !ok {
+			return fmt.Sprintf("%s: buffer closed\n", j.Name())
+		}
+		if j.q1 > buf.Len() {
+			return fmt.Sprintf("%s: dot no longer in buffer\n", j.Name())
+		}
+		buf.Replace(j.q0, j.q1, j.out.Bytes())
+	}
+	return ""
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package editor
+
+import "os/exec"
+
+// setpgid does nothing, since there are no process groups here.
+func setpgid(c *exec.Cmd) {}
+
+// kill terminates the process of c.
+func kill(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package editor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setpgid makes c the leader of a new process group, so that everything started by the shell can be killed along with it.
+func setpgid(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// kill terminates the process group of c.
+func kill(c *exec.Cmd) error {
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
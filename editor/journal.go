@@ -0,0 +1,133 @@
+package editor
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// journal is the undo history of a file as stored on disk between sessions. It is only valid for a file with the same checksum as the text in the current state.
+type journal struct {
+	Sha256 string         // of the text in the current state
+	Cur    int            // sequence number of the current state
+	States []journalState // in order of creation, starting with the root
+}
+
+type journalState struct {
+	Parent  int // sequence number, which is always less than the one of the state
+	Redo    int
+	Time    time.Time
+	Changes []journalChange
+}
+
+type journalChange struct {
+	Offset  int64
+	Action  HistoryAction
+	Content []byte
+	Time    time.Time
+}
+
+// journal returns h as a journal for text with the checksum sum.
+func (h *History) journal(sum string) *journal {
+	h.init()
+	j := &journal{Sha256: sum, Cur: h.cur.seq}
+	for _, s := range h.states {
+		js := journalState{Redo: s.redo, Time: s.time}
+		if s.parent != nil {
+			js.Parent = s.parent.seq
+		}
+		for _, c := range s.cs {
+			js.Changes = append(js.Changes, journalChange{c.offset, c.action, c.content, c.time})
+		}
+		j.States = append(j.States, js)
+	}
+	return j
+}
+
+// restore replaces h with the history in j.
+func (h *History) restore(j *journal) error {
+	if len(j.States) == 0 || j.Cur < 0 || j.Cur >= len(j.States) {
+		return errors.New("bad journal")
+	}
+	states := make([]*state, len(j.States))
+	for i, js := range j.States {
+		s := &state{redo: js.Redo, seq: i, time: js.Time}
+		for _, c := range js.Changes {
+			s.cs = append(s.cs, &Change{offset: c.Offset, action: c.Action, content: c.Content, time: c.Time})
+		}
+		if i > 0 {
+			if js.Parent < 0 || js.Parent >= i {
+				return errors.New("bad journal")
+			}
+			s.parent = states[js.Parent]
+			s.level = s.parent.level + 1
+			s.parent.children = append(s.parent.children, s)
+		}
+		states[i] = s
+	}
+	for _, s := range states {
+		if s.redo < 0 || s.redo != 0 && s.redo >= len(s.children) {
+			return errors.New("bad journal")
+		}
+	}
+	*h = History{cur: states[j.Cur], states: states}
+	return nil
+}
+
+// loadHistory reads the undo history of the file from the undo directory, if it is set and has a journal matching the text that was read.
+func (b *Buffer) loadHistory() error {
+	if b.settings == nil || b.settings.UndoDir == "" || b.file == nil {
+		return nil
+	}
+	f, err := os.Open(mangle(b.settings.UndoDir, b.Name()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var j journal
+	if err := gob.NewDecoder(f).Decode(&j); err != nil {
+		return errors.Wrap(err, "undo journal")
+	}
+	if j.Sha256 != b.file.sha256 {
+		return nil // the file has changed since
+	}
+	return errors.Wrap(b.history.restore(&j), "undo journal")
+}
+
+// SaveHistory writes the undo history of the buffer to the undo directory of the editor settings, so that it is available the next time the file is read. Nothing is written if there is no undo directory, if the text differs from the file on disk, or if the file is paged.
+func (b *Buffer) SaveHistory() error {
+	if b.settings == nil || b.settings.UndoDir == "" || b.file == nil || !b.file.read || b.what != BufferFile || b.Paged() || b.file.sha256 == "" {
+		return nil
+	}
+	b.initBuffer()
+	data, err := b.file.encode(b.buf.Bytes())
+	if err != nil {
+		return nil // cannot be on disk
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	if sum != b.file.sha256 {
+		return nil
+	}
+
+	if err := os.MkdirAll(b.settings.UndoDir, 0700); err != nil {
+		return err
+	}
+	fn := mangle(b.settings.UndoDir, b.Name())
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(b.history.journal(sum)); err != nil {
+		f.Close()
+		return errors.Wrap(err, "undo journal")
+	}
+	return f.Close()
+}
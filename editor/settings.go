@@ -0,0 +1,19 @@
+package editor
+
+// Backup policies of Settings.
+const (
+	BackupNone     = ""
+	BackupSimple   = "simple"   // a single file~
+	BackupNumbered = "numbered" // file.~1~, file.~2~ and so on
+)
+
+// Settings are the options of an editor, usually given on the command line. They apply to all buffers of the editor.
+type Settings struct {
+	UndoDir   string // directory to keep undo history of files in between sessions, or empty to not keep it
+	Backup    string // backup policy when saving a file, one of the Backup constants
+	BackupDir string // directory to keep backups in, or empty to keep them next to the file
+	Backups   int    // number of numbered backups to keep of each file, or 0 to keep all
+	SwapDir   string // directory to keep recovery files of changed buffers in, or empty to not write them
+	Encoding  string // encoding to read files in, or empty to guess it for each file
+	LargeFile int64  // size from which files are read from disk as needed instead of into memory, or 0 to read all files into memory
+}
@@ -0,0 +1,196 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/prodhe/poe/gapbuffer"
+	"github.com/prodhe/poe/piecetable"
+)
+
+// storage holds the bytes of a buffer, in a gap buffer or a paged piece table.
+type storage interface {
+	io.Seeker
+	io.Writer
+	ByteAt(offset int64) (byte, error)
+	ReadAt(p []byte, offset int64) (int, error)
+	Bytes() []byte
+	DeleteRange(offset, n int64) (int64, error)
+	Destroy()
+	Len() int64
+	LineCount() int64
+	LineStart(n int64) int64
+	LineOf(offset int64) int64
+}
+
+// outOfRange returns true if err is the error of either storage for an offset before the start of the text.
+func outOfRange(err error) bool {
+	return err == gapbuffer.ErrOutOfRange || err == piecetable.ErrOutOfRange
+}
+
+// pagedSniff is how much of the start of a large file is read to tell if it can be paged.
+const pagedSniff = 64 << 10
+
+// scanSize is how much of a paged buffer is read at a time when looking for a byte.
+const scanSize = 4 << 10
+
+// indexByte returns the offset of the first c at or after offset, or -1 if there is none.
+func (b *Buffer) indexByte(c byte, offset int64) int64 {
+	p := make([]byte, scanSize) // a part at a time, as a paged buffer is not held in memory
+	for offset < b.buf.Len() {
+		n, err := b.buf.ReadAt(p, offset)
+		if i := bytes.IndexByte(p[:n], c); i >= 0 {
+			return offset + int64(i)
+		}
+		if err != nil {
+			break
+		}
+		offset += int64(n)
+	}
+	return -1
+}
+
+// lastIndexByte returns the offset of the last c before offset, or -1 if there is none.
+func (b *Buffer) lastIndexByte(c byte, offset int64) int64 {
+	p := make([]byte, scanSize)
+	for offset > 0 {
+		k := offset - scanSize
+		if k < 0 {
+			k = 0
+		}
+		n, err := b.buf.ReadAt(p[:offset-k], k)
+		if i := bytes.LastIndexByte(p[:n], c); i >= 0 {
+			return k + int64(i)
+		}
+		if err != nil {
+			break
+		}
+		offset = k
+	}
+	return -1
+}
+
+// Paged returns true if the file of the buffer is read from disk as needed instead of being held in memory.
+func (b *Buffer) Paged() bool {
+	_, ok := b.buf.(*piecetable.Buffer)
+	return ok
+}
+
+// loadPaged opens the file of the buffer to be read as needed, or returns nil if it cannot be paged.
+func (b *Buffer) loadPaged() (*piecetable.Buffer, error) {
+	if b.settings == nil || b.settings.LargeFile <= 0 {
+		return nil, nil
+	}
+	info, err := os.Stat(b.file.name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() < b.settings.LargeFile {
+		return nil, nil // left to load
+	}
+
+	fh, err := os.Open(b.file.name)
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	sniff := make([]byte, pagedSniff)
+	n, err := fh.ReadAt(sniff, 0)
+	if err != nil && err != io.EOF {
+		fh.Close()
+		return nil, fmt.Errorf("%s", err)
+	}
+	sniff = sniff[:n]
+	// a rune cut off at the end of the sniff is not invalid UTF-8
+	for i := len(sniff) - 1; i >= 0 && i >= len(sniff)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(sniff[i]) {
+			if !utf8.FullRune(sniff[i:]) {
+				sniff = sniff[:i]
+			}
+			break
+		}
+	}
+
+	// only text that is kept byte for byte on save can be paged
+	f := *b.file
+	f.detect(sniff, b.readEncoding())
+	if !f.binary && (f.encoding != EncodingUTF8 || f.bom || f.crlf) {
+		fh.Close()
+		return nil, nil
+	}
+
+	*b.file = f
+	b.file.sha256 = "" // not hashed, as that reads all of it, so any change on disk counts
+	b.file.mtime = info.ModTime()
+	b.file.read = true
+	b.file.new = false
+	b.file.readonly = !writable(b.file.name) || b.file.binary
+	b.what = BufferFile
+
+	return piecetable.New(fh, info.Size()), nil
+}
+
+// reopen reads a paged file again by opening it anew.
+func (b *Buffer) reopen() error {
+	// not compared line by line like other files, as that would read all of it
+	p, err := b.loadPaged()
+	if err != nil {
+		return err
+	}
+	var buf storage = p
+	if p == nil {
+		text, err := b.load()
+		if err != nil {
+			return err
+		}
+		if b.file.new {
+			return fmt.Errorf("%s: no such file", b.file.name)
+		}
+		buf = &gapbuffer.Buffer{}
+		buf.Write(text)
+	}
+	b.buf.Destroy()
+	b.buf = buf
+
+	for _, m := range b.marks {
+		if *m > b.buf.Len() {
+			*m = b.buf.Len()
+		}
+	}
+	b.SetDot(b.q0, b.q1)
+	b.history = History{} // it no longer matches the text
+	b.dirty = false
+	b.stale = false
+	return nil
+}
+
+// encoded returns a reader of the text as it is written to file f.
+func (b *Buffer) encoded(f *file) (io.Reader, error) {
+	if b.Paged() {
+		// read while it is written, and never converted
+		return io.NewSectionReader(b.buf, 0, b.buf.Len()), nil
+	}
+	data, err := f.encode(b.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// repage points the storage of a paged buffer at its file after it has been saved.
+func (b *Buffer) repage() {
+	if !b.Paged() {
+		return
+	}
+	fh, err := os.Open(b.file.name)
+	if err != nil {
+		return // the old file is still there to read
+	}
+	info, err := fh.Stat()
+	if err != nil || info.Size() != b.buf.Len() {
+		fh.Close()
+		return
+	}
+	// lets go of the edits held in memory and of the replaced file, while the dot, marks and undo still apply
+	b.buf.Destroy()
+	b.buf = piecetable.New(fh, info.Size())
+}
@@ -0,0 +1,118 @@
+package editor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// swapName returns the name of the recovery file of the buffer, or an empty string if the settings have no swap directory or the buffer has no file.
+func (b *Buffer) swapName() string {
+	if b.settings == nil || b.settings.SwapDir == "" || b.file == nil || b.file.name == "" {
+		return ""
+	}
+	return mangle(b.settings.SwapDir, b.Name())
+}
+
+// WriteSwap writes the text of a changed buffer to its recovery file in the swap directory, so that it can be recovered if poe dies before it is saved. Nothing is written if the text is the same as last time, or for a paged file, which would mean writing all of it.
+func (b *Buffer) WriteSwap() error {
+	name := b.swapName()
+	if name == "" || !b.file.read || b.what != BufferFile || !b.dirty || b.Paged() {
+		return nil
+	}
+	t := b.history.Time()
+	if b.swap && t.Equal(b.swapped) {
+		return nil
+	}
+
+	if err := os.MkdirAll(b.settings.SwapDir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(b.settings.SwapDir, ".swap")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.buf.Bytes())
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "swap")
+	}
+	b.swap = true
+	b.swapped = t
+	return nil
+}
+
+// RemoveSwap removes the recovery file written by the buffer, if any.
+func (b *Buffer) RemoveSwap() {
+	if !b.swap {
+		return
+	}
+	os.Remove(b.swapName())
+	b.swap = false
+	b.swapped = time.Time{}
+}
+
+// SwapFile returns the name of a recovery file left over for the file of the buffer, for example when poe crashed. It is empty if there is none, if the file is paged, or if it holds the same text as the file on disk, in which case it is removed. The recovery file holds the text of the buffer, so the file is decoded like when it was read before comparing them.
+func (b *Buffer) SwapFile() string {
+	name := b.swapName()
+	if name == "" || b.swap || b.Paged() {
+		return ""
+	}
+	swap, err := ioutil.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	if disk, err := ioutil.ReadFile(b.file.name); err == nil && bytes.Equal(b.file.decode(disk), swap) {
+		os.Remove(name)
+		return ""
+	}
+	return name
+}
+
+// DiffSwap returns the differences between the file on disk and its recovery file as a unified diff.
+func (b *Buffer) DiffSwap() (string, error) {
+	name := b.SwapFile()
+	if name == "" {
+		return "", errors.New("no recovery file")
+	}
+	swap, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	disk, err := ioutil.ReadFile(b.file.name)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	a, c := splitLines(b.file.decode(disk)), splitLines(swap)
+	return unified(b.file.name, filepath.Base(name), a, c, diffLines(a, c)), nil
+}
+
+// Recover replaces the text of the buffer with its recovery file. It is undone like any other change, and from then on the recovery file belongs to the buffer.
+func (b *Buffer) Recover() error {
+	name := b.SwapFile()
+	if name == "" {
+		return errors.New("no recovery file")
+	}
+	swap, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := b.Replace(0, b.Len(), swap); err != nil {
+		return err
+	}
+	b.SetDot(0, 0)
+	b.swap = true
+	return nil
+}
@@ -1,8 +1,10 @@
 package gapbuffer
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"sort"
 )
 
 // ErrOutOfRange is returned when given position is out of range for the buffer.
@@ -11,8 +13,12 @@ var ErrOutOfRange = errors.New("index out of range")
 type Buffer struct {
 	buf       []byte
 	bootstrap [64]byte
-	start     int // gap start, is considered empty
-	end       int // gap end, holds next byte counting from before the gap
+	start     int64 // gap start, is considered empty
+	end       int64 // gap end, holds next byte counting from before the gap
+
+	// The offsets of all newlines are kept on either side of the gap, so that only those passed by the gap need updating. Those after it are counted from the end of the data, which does not change when writing or deleting at the gap.
+	nlBefore []int64 // offsets of newlines before the gap, in order
+	nlAfter  []int64 // distance from the end of the data to newlines after the gap, nearest the end first
 }
 
 func (b *Buffer) Bytes() []byte {
@@ -24,12 +30,14 @@ func (b *Buffer) Bytes() []byte {
 // Destroy will erase the Buffer by zeroising all fields.
 func (b *Buffer) Destroy() {
 	b.start = 0
-	b.end = len(b.buf)
+	b.end = int64(len(b.buf))
+	b.nlBefore = b.nlBefore[:0]
+	b.nlAfter = b.nlAfter[:0]
 }
 
 // Byte returns current byte right after the gap. If the gap is at the end, the return will be 0.
 func (b *Buffer) Byte() byte {
-	if b.end >= len(b.buf) {
+	if b.end >= int64(len(b.buf)) {
 		return 0 // EOF
 	}
 
@@ -37,7 +45,7 @@ func (b *Buffer) Byte() byte {
 }
 
 // ByteAt returns the byte at the given offset, ignoring and hiding the gap.
-func (b *Buffer) ByteAt(offset int) (byte, error) {
+func (b *Buffer) ByteAt(offset int64) (byte, error) {
 	if offset >= b.start {
 		offset += b.gapLen()
 	}
@@ -45,7 +53,7 @@ func (b *Buffer) ByteAt(offset int) (byte, error) {
 	if offset < 0 {
 		return 0, ErrOutOfRange
 	}
-	if offset >= len(b.buf) {
+	if offset >= int64(len(b.buf)) {
 		return 0, io.EOF
 	}
 
@@ -53,67 +61,67 @@ func (b *Buffer) ByteAt(offset int) (byte, error) {
 }
 
 // gapLen returns the length of the gap.
-func (b *Buffer) gapLen() int {
+func (b *Buffer) gapLen() int64 {
 	return b.end - b.start
 }
 
 // Len returns the length of actual data.
-func (b *Buffer) Len() int {
-	return len(b.buf) - b.gapLen()
+func (b *Buffer) Len() int64 {
+	return int64(len(b.buf)) - b.gapLen()
 }
 
 // Cap returns the capacity of the Buffer, including the gap.
-func (b *Buffer) Cap() int {
-	return cap(b.buf)
+func (b *Buffer) Cap() int64 {
+	return int64(cap(b.buf))
 }
 
 // Pos returns the current start position of the gap. This is where next write will appear.
-func (b *Buffer) Pos() int {
+func (b *Buffer) Pos() int64 {
 	return b.start
 }
 
-// Seek is moving the gap to a given offset from origin.
-func (b *Buffer) Seek(newpos int) {
+// Seek implements io.Seeker by moving the gap to offset, relative to the start, the gap or the end according to whence. An offset past the end moves the gap to the end. The bytes between the old and the new position are moved across the gap in one go. Returns the new position of the gap.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	newpos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newpos += b.start
+	case io.SeekEnd:
+		newpos += b.Len()
+	default:
+		return b.start, errors.New("invalid whence")
+	}
+
 	// out of range
 	if newpos < 0 {
-		//b.Seek(0)
-		panic("index below zero")
-		return
+		return b.start, ErrOutOfRange
 	}
 	if newpos > b.Len() {
-		b.Seek(b.Len())
-		return
+		newpos = b.Len()
 	}
 
-	if newpos < b.start { // move backwards
-		for b.start > newpos {
-			b.backward()
+	switch {
+	case newpos < b.start: // move backwards
+		n := b.start - newpos
+		for k := len(b.nlBefore) - 1; k >= 0 && b.nlBefore[k] >= newpos; k-- {
+			b.nlAfter = append(b.nlAfter, b.Len()-b.nlBefore[k])
+			b.nlBefore = b.nlBefore[:k]
 		}
-	} else if newpos > b.start { // move forward
-		for b.start < newpos {
-			b.forward()
+		copy(b.buf[b.end-n:b.end], b.buf[newpos:b.start])
+		b.start -= n
+		b.end -= n
+	case newpos > b.start: // move forward
+		n := newpos - b.start
+		for k := len(b.nlAfter) - 1; k >= 0 && b.Len()-b.nlAfter[k] < newpos; k-- {
+			b.nlBefore = append(b.nlBefore, b.Len()-b.nlAfter[k])
+			b.nlAfter = b.nlAfter[:k]
 		}
+		copy(b.buf[b.start:b.start+n], b.buf[b.end:b.end+n])
+		b.start += n
+		b.end += n
 	}
-}
-
-// Forward is moving the gap one byte forward.
-func (b *Buffer) forward() {
-	if b.end >= len(b.buf) {
-		return
-	}
-	b.buf[b.start] = b.buf[b.end]
-	b.start++
-	b.end++
-}
-
-// Backward is moving the gap one byte backwards.
-func (b *Buffer) backward() {
-	if b.start <= 0 {
-		return
-	}
-	b.buf[b.end-1] = b.buf[b.start-1]
-	b.start--
-	b.end--
+	return b.start, nil
 }
 
 // Delete will expand the gap by 1, deleting the character before the gap. Returns the byte that was deleted.
@@ -122,31 +130,59 @@ func (b *Buffer) Delete() byte {
 		return 0
 	}
 	b.start--
+	if b.buf[b.start] == '\n' {
+		b.nlBefore = b.nlBefore[:len(b.nlBefore)-1]
+	}
 	return b.buf[b.start]
 }
 
-// grow will grow the buffer if necessary.
-func (b *Buffer) grow() {
-	if b.buf == nil {
-		b.buf = b.bootstrap[:]
-		fill := make([]byte, cap(b.buf))
-		b.buf = append(b.buf, fill...)
+// DeleteRange deletes n bytes from offset, or as many as there are, by moving the gap there and expanding it over them. Returns the number of bytes deleted.
+func (b *Buffer) DeleteRange(offset, n int64) (int64, error) {
+	if offset < 0 || offset > b.Len() {
+		return 0, ErrOutOfRange
+	}
+	if n > b.Len()-offset {
+		n = b.Len() - offset
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	b.Seek(offset, io.SeekStart)
+	d := b.Len() - offset - n // distance from the end of the data to the end of the deleted bytes
+	for k := len(b.nlAfter) - 1; k >= 0 && b.nlAfter[k] > d; k-- {
+		b.nlAfter = b.nlAfter[:k]
+	}
+	b.end += n
+	return n, nil
+}
 
+// Grow makes room for n more bytes in the gap, so that writing them does not allocate. It is meant for reading a file of known size without the buffer doubling along the way.
+func (b *Buffer) Grow(n int64) {
+	b.grow(n)
+}
+
+// grow makes room for at least n more bytes in the gap. The buffer is at least doubled, so that writing byte by byte does not allocate every time.
+func (b *Buffer) grow(n int64) {
+	if b.gapLen() >= n {
+		return
+	}
+	if b.buf == nil && n <= int64(len(b.bootstrap)) {
+		b.buf = b.bootstrap[:]
 		b.start = 0
-		b.end = cap(b.buf)
+		b.end = int64(len(b.buf))
 		return
 	}
-	oldpos := b.start
-	b.Seek(cap(b.buf))
-
-	b.buf = append(b.buf, 0)
-	exp := cap(b.buf)
-	b.end += exp + 1
-
-	fill := make([]byte, exp)
-	b.buf = append(b.buf, fill...)
 
-	b.Seek(oldpos)
+	size := 2 * int64(len(b.buf))
+	if size < b.Len()+n {
+		size = b.Len() + n
+	}
+	buf := make([]byte, size)
+	copy(buf, b.buf[:b.start])
+	after := int64(len(b.buf)) - b.end
+	copy(buf[size-after:], b.buf[b.end:])
+	b.buf = buf
+	b.end = size - after
 }
 
 // Write writes p into the Buffer at current gap position. The Buffer will expand if needed and this is the only time any new memory allocation is done. The resizing and expanding strategy is handled by the underlying internal byte slice. Cap() will return the size of this slice.
@@ -156,23 +192,71 @@ func (b *Buffer) Write(p []byte) (int, error) {
 	if p == nil {
 		return 0, nil
 	}
-	for _, c := range p {
-		if b.gapLen() == 0 {
-			b.grow()
+	b.grow(int64(len(p)))
+	copy(b.buf[b.start:], p)
+	for i := bytes.IndexByte(p, '\n'); i >= 0; {
+		b.nlBefore = append(b.nlBefore, b.start+int64(i))
+		k := bytes.IndexByte(p[i+1:], '\n')
+		if k < 0 {
+			break
 		}
-		b.buf[b.start] = c
-		b.start++
+		i += 1 + k
 	}
+	b.start += int64(len(p))
 	return len(p), nil
 }
 
+// LineCount returns the number of lines, which is one more than the number of newlines. The last line is empty if the data ends with a newline.
+func (b *Buffer) LineCount() int64 {
+	return int64(len(b.nlBefore)+len(b.nlAfter)) + 1
+}
+
+// newline returns the offset of newline k, counting from 0.
+func (b *Buffer) newline(k int64) int64 {
+	if k < int64(len(b.nlBefore)) {
+		return b.nlBefore[k]
+	}
+	k -= int64(len(b.nlBefore))
+	return b.Len() - b.nlAfter[int64(len(b.nlAfter))-1-k]
+}
+
+// LineStart returns the offset of the start of line n, counting from 0. Lines before the first or after the last are taken to be the first or the last.
+func (b *Buffer) LineStart(n int64) int64 {
+	if n >= b.LineCount() {
+		n = b.LineCount() - 1
+	}
+	if n <= 0 {
+		return 0
+	}
+	return b.newline(n-1) + 1
+}
+
+// LineOf returns the line that offset is on, counting from 0, which is the number of newlines before it.
+func (b *Buffer) LineOf(offset int64) int64 {
+	if offset <= b.start {
+		return int64(sort.Search(len(b.nlBefore), func(i int) bool {
+			return b.nlBefore[i] >= offset
+		}))
+	}
+	d := b.Len() - offset
+	return int64(len(b.nlBefore) + len(b.nlAfter) - sort.Search(len(b.nlAfter), func(i int) bool {
+		return b.nlAfter[i] > d
+	}))
+}
+
+// OffsetToLineCol returns the line that offset is on and the number of bytes before it on that line, both counting from 0.
+func (b *Buffer) OffsetToLineCol(offset int64) (line, col int64) {
+	line = b.LineOf(offset)
+	return line, offset - b.LineStart(line)
+}
+
 // Read implements io.Reader, returning number of bytes from the Buffer while ignoring the gap.
 func (b *Buffer) Read(p []byte) (int, error) {
 	return b.ReadAt(p, b.end)
 }
 
 // ReadAt fills p with bytes starting at offset from the Buffer, ignoring the gap. Returns number of bytes and an error.
-func (b *Buffer) ReadAt(p []byte, offset int) (n int, err error) {
+func (b *Buffer) ReadAt(p []byte, offset int64) (n int, err error) {
 	if offset < 0 {
 		return 0, ErrOutOfRange
 	}
@@ -180,15 +264,11 @@ func (b *Buffer) ReadAt(p []byte, offset int) (n int, err error) {
 		return 0, io.EOF
 	}
 
-	for n = 0; n < len(p) && offset < b.Len(); n++ {
-		c, err := b.ByteAt(offset)
-		if err != nil {
-			return n, err
-		}
-
-		p[n] = c
-		offset++
+	if offset < b.start {
+		n = copy(p, b.buf[offset:b.start])
+		offset = b.start
 	}
+	n += copy(p[n:], b.buf[offset+b.gapLen():])
 
 	return n, nil
 }
@@ -2,6 +2,7 @@ package gapbuffer_test
 
 import (
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/prodhe/poe/gapbuffer"
@@ -31,7 +32,7 @@ func TestReadAt(t *testing.T) {
 
 	var tt = []struct {
 		name      string
-		offset    int
+		offset    int64
 		len       int
 		want      int
 		wantbytes []byte
@@ -46,7 +47,7 @@ func TestReadAt(t *testing.T) {
 
 	for _, tc := range tt {
 		b := make([]byte, tc.len)
-		gb.Seek(0)
+		gb.Seek(0, io.SeekStart)
 		n, err := gb.ReadAt(b, tc.offset)
 		if n != tc.len || err != tc.wanterr || !sliceEqual(b, tc.wantbytes) {
 			t.Errorf("seek 0: %s: expected %q %x (%d) (err: %v) (len: %d), got %q %x (%d) (err: %v) (len: %d)", tc.name, tc.wantbytes, tc.wantbytes, tc.want, tc.wanterr, tc.len, b, b, n, err, len(b))
@@ -60,16 +61,16 @@ func TestSeekAndPos(t *testing.T) {
 
 	var tt = []struct {
 		name   string
-		offset int
-		want   int
+		offset int64
+		want   int64
 	}{
 		{"beginning", 0, 0},
 		{"middle", 25, 25},
-		{"end", len(lipsum), len(lipsum)},
+		{"end", int64(len(lipsum)), int64(len(lipsum))},
 	}
 
 	for _, tc := range tt {
-		gb.Seek(tc.offset)
+		gb.Seek(tc.offset, io.SeekStart)
 		pos := gb.Pos()
 		if pos != tc.want {
 			t.Errorf("%s: set %d, expected %d, got %d", tc.name, tc.offset, tc.want, pos)
@@ -107,7 +108,7 @@ func TestWriteMulti(t *testing.T) {
 	var tt = []struct {
 		name   string
 		input1 []byte
-		offset int
+		offset int64
 		input2 []byte
 		want   []byte
 	}{
@@ -120,7 +121,7 @@ func TestWriteMulti(t *testing.T) {
 	for _, tc := range tt {
 		b := gapbuffer.Buffer{}
 		b.Write(tc.input1)
-		b.Seek(tc.offset)
+		b.Seek(tc.offset, io.SeekStart)
 		b.Write(tc.input2)
 		data := b.Bytes()
 		if !sliceEqual(data, tc.want) {
@@ -133,7 +134,7 @@ func TestByteAt(t *testing.T) {
 	var tt = []struct {
 		name    string
 		input   []byte
-		pos     int
+		pos     int64
 		want    byte
 		wanterr error
 	}{
@@ -148,7 +149,7 @@ func TestByteAt(t *testing.T) {
 	for _, tc := range tt {
 		b := gapbuffer.Buffer{}
 		b.Write(tc.input)
-		b.Seek(0)
+		b.Seek(0, io.SeekStart)
 		c, err := b.ByteAt(tc.pos)
 		if c != tc.want {
 			t.Errorf("Seek(0): %s: expected %c (%x) and %v, got %c (%x) and %v", tc.name, tc.want, tc.want, tc.wanterr, c, c, err)
@@ -158,7 +159,7 @@ func TestByteAt(t *testing.T) {
 	for _, tc := range tt {
 		b := gapbuffer.Buffer{}
 		b.Write(tc.input)
-		b.Seek(2)
+		b.Seek(2, io.SeekStart)
 		c, err := b.ByteAt(tc.pos)
 		if c != tc.want {
 			t.Errorf("Seek(2): %s: expected %c (%x) and %v, got %c (%x) and %v", tc.name, tc.want, tc.want, tc.wanterr, c, c, err)
@@ -168,10 +169,107 @@ func TestByteAt(t *testing.T) {
 	for _, tc := range tt {
 		b := gapbuffer.Buffer{}
 		b.Write(tc.input)
-		b.Seek(b.Len())
+		b.Seek(b.Len(), io.SeekStart)
 		c, err := b.ByteAt(tc.pos)
 		if c != tc.want {
 			t.Errorf("Seek(len): %s: expected %c (%x) and %v, got %c (%x) and %v", tc.name, tc.want, tc.want, tc.wanterr, c, c, err)
 		}
 	}
 }
+
+func TestLines(t *testing.T) {
+	gb := gapbuffer.Buffer{}
+	gb.Write([]byte("one\ntwo\n"))
+	gb.Seek(4, io.SeekStart)
+	gb.Write([]byte("1\n2\n")) // one\n1\n2\ntwo\n
+	gb.Seek(2, io.SeekStart)
+	gb.Delete() // oe\n1\n2\ntwo\n
+	gb.Seek(5, io.SeekStart)
+	gb.Delete() // oe\n12\ntwo\n
+	gb.Seek(gb.Len(), io.SeekStart)
+
+	if got := string(gb.Bytes()); got != "oe\n12\ntwo\n" {
+		t.Fatalf("expected %q, got %q", "oe\n12\ntwo\n", got)
+	}
+	if n := gb.LineCount(); n != 4 {
+		t.Errorf("expected 4 lines, got %d", n)
+	}
+
+	var tt = []struct {
+		offset    int64
+		line, col int64
+	}{
+		{0, 0, 0},
+		{2, 0, 2},
+		{3, 1, 0},
+		{5, 1, 2},
+		{6, 2, 0},
+		{9, 2, 3},
+		{10, 3, 0},
+	}
+	// the same wherever the gap is
+	for _, pos := range []int64{0, 4, 10} {
+		gb.Seek(pos, io.SeekStart)
+		for _, tc := range tt {
+			if line, col := gb.OffsetToLineCol(tc.offset); line != tc.line || col != tc.col {
+				t.Errorf("gap at %d: offset %d: expected line %d col %d, got line %d col %d", pos, tc.offset, tc.line, tc.col, line, col)
+			}
+			if start := gb.LineStart(tc.line); start != tc.offset-tc.col {
+				t.Errorf("gap at %d: expected line %d to start at %d, got %d", pos, tc.line, tc.offset-tc.col, start)
+			}
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	var tt = []struct {
+		name    string
+		offset  int64
+		n       int64
+		want    string
+		wantn   int64
+		wanterr error
+	}{
+		{"beginning", 0, 4, "two\nthree", 4, nil},
+		{"middle", 2, 6, "onthree", 6, nil},
+		{"end", 8, 5, "one\ntwo\n", 5, nil},
+		{"past end", 4, 100, "one\n", 9, nil},
+		{"nothing", 3, 0, "one\ntwo\nthree", 0, nil},
+		{"out of range", 14, 1, "one\ntwo\nthree", 0, gapbuffer.ErrOutOfRange},
+	}
+
+	for _, tc := range tt {
+		// with the gap before, inside and after the range
+		for _, pos := range []int64{0, tc.offset + tc.n/2, 13} {
+			b := gapbuffer.Buffer{}
+			b.Write([]byte("one\ntwo\nthree"))
+			b.Seek(pos, io.SeekStart)
+			n, err := b.DeleteRange(tc.offset, tc.n)
+			if got := string(b.Bytes()); got != tc.want || n != tc.wantn || err != tc.wanterr {
+				t.Errorf("%s: gap at %d: expected %q (%d, %v), got %q (%d, %v)", tc.name, pos, tc.want, tc.wantn, tc.wanterr, got, n, err)
+			}
+			if lines := b.LineCount(); lines != 1+int64(strings.Count(tc.want, "\n")) {
+				t.Errorf("%s: gap at %d: expected %d lines, got %d", tc.name, pos, 1+strings.Count(tc.want, "\n"), lines)
+			}
+		}
+	}
+}
+
+func TestWriteLarge(t *testing.T) {
+	big := strings.Repeat(lipsum, 100)
+	b := gapbuffer.Buffer{}
+	b.Write([]byte("<>"))
+	b.Seek(1, io.SeekStart)
+	b.Write([]byte(big))
+	if got := string(b.Bytes()); got != "<"+big+">" {
+		t.Errorf("expected %d bytes between <>, got %d bytes", len(big), len(got))
+	}
+	b.Seek(0, io.SeekStart)
+	b.Seek(b.Len(), io.SeekStart)
+	if got := string(b.Bytes()); got != "<"+big+">" {
+		t.Errorf("expected data to survive seeking, got %d bytes", len(got))
+	}
+	if n := b.LineCount(); n != 1+int64(strings.Count(big, "\n")) {
+		t.Errorf("expected %d lines, got %d", 1+strings.Count(big, "\n"), n)
+	}
+}
@@ -0,0 +1,94 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package gapbuffer_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prodhe/poe/gapbuffer"
+)
+
+// large is the size of the sparse file in TestLargeFile, just beyond what fits in an int32.
+const large = 1<<31 + 16
+
+// readLarge reads a sparse file of size large into a buffer. The file is all zero bytes apart from a line at the start and one at the end.
+func readLarge(t *testing.T) *gapbuffer.Buffer {
+	f, err := ioutil.TempFile("", "poe-large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString("head\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("tail\n"), large-5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &gapbuffer.Buffer{}
+	b.Grow(large + 4) // room for what the test writes, as growing doubles the buffer
+	if n, err := io.Copy(b, f); err != nil || n != large {
+		t.Fatalf("read %d bytes of %d: %v", n, large, err)
+	}
+	return b
+}
+
+func TestLargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping file beyond 2 GiB in short mode")
+	}
+	b := readLarge(t)
+
+	if n := b.Len(); n != large {
+		t.Fatalf("expected length %d, got %d", int64(large), n)
+	}
+	if n := b.LineCount(); n != 3 {
+		t.Errorf("expected 3 lines, got %d", n)
+	}
+	if q := b.LineStart(2); q != large {
+		t.Errorf("expected line 3 at %d, got %d", int64(large), q)
+	}
+	if line, col := b.OffsetToLineCol(large - 3); line != 1 || col != large-8 {
+		t.Errorf("expected line 1 col %d, got line %d col %d", int64(large-8), line, col)
+	}
+
+	// write at the end and in the middle, across the 2 GiB mark
+	b.Seek(large-5, io.SeekStart)
+	b.Write([]byte("\n"))
+	b.Seek(1<<31, io.SeekStart)
+	b.Write([]byte("mid"))
+	if n := b.LineCount(); n != 4 {
+		t.Errorf("expected 4 lines after writing, got %d", n)
+	}
+
+	p := make([]byte, 12)
+	if _, err := b.ReadAt(p, large-8); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := []byte("\x00\x00\x00\x00\x00\x00\ntail\n"); !bytes.Equal(p[:len(want)], want) {
+		t.Errorf("expected end %q, got %q", want, p)
+	}
+	if got, _ := b.ByteAt(1<<31 + 1); got != 'i' {
+		t.Errorf("expected 'i' beyond 2 GiB, got %q", got)
+	}
+
+	// delete the middle, which moves the gap back to the start
+	if n, err := b.DeleteRange(5, 1<<31-5); err != nil || n != 1<<31-5 {
+		t.Fatalf("expected to delete %d bytes, got %d: %v", int64(1<<31-5), n, err)
+	}
+	if got, want := string(b.Bytes()), "head\nmid"+strings.Repeat("\x00", 11)+"\ntail\n"; got != want {
+		t.Errorf("expected %q to be left around the middle, got %q", want, got)
+	}
+}
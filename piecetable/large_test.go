@@ -0,0 +1,68 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package piecetable_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prodhe/poe/piecetable"
+)
+
+// large is the size of the sparse file in TestLargeFile, just beyond what fits in an int32.
+const large = 1<<31 + 16
+
+func TestLargeFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "poe-large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("head\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("tail\n"), large-5); err != nil {
+		t.Fatal(err)
+	}
+
+	b := piecetable.New(f, large)
+	defer b.Destroy()
+
+	if c, err := b.ByteAt(large - 2); err != nil || c != 'l' {
+		t.Errorf("expected 'l' at the end, got %q (%v)", c, err)
+	}
+
+	// write beyond 2 GiB and read across it
+	b.Seek(1<<31, io.SeekStart)
+	b.Write([]byte("mid"))
+	p := make([]byte, 8)
+	if _, err := b.ReadAt(p, 1<<31-2); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("\x00\x00mid\x00\x00\x00"); !bytes.Equal(p, want) {
+		t.Errorf("expected %q, got %q", want, p)
+	}
+	if !testing.Short() { // reads through all of it
+		if n := b.LineCount(); n != 3 {
+			t.Errorf("expected 3 lines, got %d", n)
+		}
+		if q := b.LineStart(2); q != b.Len() {
+			t.Errorf("expected the last line at %d, got %d", b.Len(), q)
+		}
+	}
+
+	if n, err := b.DeleteRange(5, 1<<31-5); err != nil || n != 1<<31-5 {
+		t.Fatalf("expected to delete %d bytes, got %d: %v", int64(1<<31-5), n, err)
+	}
+	if n := b.Len(); n != large+3-(1<<31-5) {
+		t.Errorf("expected length %d, got %d", int64(large+3-(1<<31-5)), n)
+	}
+	if got := string(b.Bytes()[:8]); got != "head\nmid" {
+		t.Errorf("expected %q left at the start, got %q", "head\nmid", got)
+	}
+}
@@ -0,0 +1,401 @@
+package piecetable
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrOutOfRange is returned when given position is out of range for the buffer.
+var ErrOutOfRange = errors.New("index out of range")
+
+const (
+	pageSize = 64 << 10 // bytes read from the original at a time
+	maxPages = 64       // pages of the original kept in memory
+)
+
+// Buffer is a piece table over an original text that is read as needed, usually a large file on disk. The original is never written to. Inserted text is appended to a buffer in memory, and the text is a list of pieces of either of them, so that opening the original reads nothing up front and an edit costs about the size of the edit.
+//
+// It has the same methods as the gap buffer. Instead of an index of every newline, the newlines in each page of the original are counted the first time a line is looked up past it, so that later lookups only read the pages they end in.
+type Buffer struct {
+	orig   io.ReaderAt
+	add    []byte // inserted text, only ever appended to
+	pieces []piece
+	length int64
+	pos    int64 // where the next write appears
+
+	pages map[int64]*page // pages of the original by offset
+	tick  uint64
+	lines map[int64]int64 // newlines in whole pages of the original by offset, kept when the pages are let go of
+}
+
+// piece is a part of the text that is in either the original or the add buffer.
+type piece struct {
+	add bool  // in the add buffer instead of the original
+	off int64 // offset in its buffer
+	n   int64
+}
+
+// page is a part of the original that has been read.
+type page struct {
+	data []byte
+	used uint64 // tick of last use, to let go of the least recently used
+}
+
+// New returns a buffer with the first size bytes of orig as its text.
+func New(orig io.ReaderAt, size int64) *Buffer {
+	b := &Buffer{orig: orig, length: size}
+	if size > 0 {
+		b.pieces = []piece{{off: 0, n: size}}
+	}
+	return b
+}
+
+// Bytes returns all of the text, which means reading all of the original that is left.
+func (b *Buffer) Bytes() []byte {
+	buf := make([]byte, b.Len())
+	b.ReadAt(buf, 0)
+	return buf
+}
+
+// Destroy empties the Buffer and lets go of the original, which is closed if it is an io.Closer.
+func (b *Buffer) Destroy() {
+	if c, ok := b.orig.(io.Closer); ok {
+		c.Close()
+	}
+	*b = Buffer{}
+}
+
+// Len returns the length of the text.
+func (b *Buffer) Len() int64 {
+	return b.length
+}
+
+// Pos returns the position where the next write appears.
+func (b *Buffer) Pos() int64 {
+	return b.pos
+}
+
+// Seek implements io.Seeker by setting where the next write appears, relative to the start, the current position or the end according to whence. An offset past the end is taken to be the end. Returns the new position.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	newpos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		newpos += b.pos
+	case io.SeekEnd:
+		newpos += b.length
+	default:
+		return b.pos, errors.New("invalid whence")
+	}
+
+	if newpos < 0 {
+		return b.pos, ErrOutOfRange
+	}
+	if newpos > b.length {
+		newpos = b.length
+	}
+	b.pos = newpos
+	return b.pos, nil
+}
+
+// find returns the index of the piece that offset is in and the offset within that piece. The end of the text is at the start of a piece past the last one.
+func (b *Buffer) find(offset int64) (int, int64) {
+	for i, p := range b.pieces {
+		if offset < p.n {
+			return i, offset
+		}
+		offset -= p.n
+	}
+	return len(b.pieces), offset
+}
+
+// split makes a piece start at offset, by splitting the piece it is in if need be, and returns the index of that piece.
+func (b *Buffer) split(offset int64) int {
+	i, k := b.find(offset)
+	if i == len(b.pieces) || k == 0 {
+		return i
+	}
+	p := b.pieces[i]
+	b.pieces = append(b.pieces, piece{})
+	copy(b.pieces[i+2:], b.pieces[i+1:])
+	b.pieces[i] = piece{add: p.add, off: p.off, n: k}
+	b.pieces[i+1] = piece{add: p.add, off: p.off + k, n: p.n - k}
+	return i + 1
+}
+
+// Write inserts p at the current position and moves past it. Writing right after the last write, as when typing, makes the piece of that write longer instead of adding one.
+//
+// It will never return any other error than nil.
+func (b *Buffer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	off, n := int64(len(b.add)), int64(len(p))
+	b.add = append(b.add, p...)
+
+	i := b.split(b.pos)
+	if last := i - 1; last >= 0 && b.pieces[last].add && b.pieces[last].off+b.pieces[last].n == off {
+		b.pieces[last].n += n
+	} else {
+		b.pieces = append(b.pieces, piece{})
+		copy(b.pieces[i+1:], b.pieces[i:])
+		b.pieces[i] = piece{add: true, off: off, n: n}
+	}
+	b.pos += n
+	b.length += n
+	return len(p), nil
+}
+
+// DeleteRange deletes n bytes from offset, or as many as there are, by cutting them out of the pieces. The position of the next write is moved to offset. Returns the number of bytes deleted.
+func (b *Buffer) DeleteRange(offset, n int64) (int64, error) {
+	if offset < 0 || offset > b.length {
+		return 0, ErrOutOfRange
+	}
+	if n > b.length-offset {
+		n = b.length - offset
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	i := b.split(offset)
+	j := b.split(offset + n)
+	b.pieces = append(b.pieces[:i], b.pieces[j:]...)
+	b.length -= n
+	b.pos = offset
+	return n, nil
+}
+
+// page returns the page of the original that offset is in and where it starts. It is read unless it is one of the pages kept, in which case the least recently used page is let go of to make room.
+func (b *Buffer) page(offset int64) ([]byte, int64, error) {
+	start := offset - offset%pageSize
+	b.tick++
+	if pg, ok := b.pages[start]; ok {
+		pg.used = b.tick
+		return pg.data, start, nil
+	}
+
+	if b.pages == nil {
+		b.pages = make(map[int64]*page)
+	}
+	var data []byte
+	if len(b.pages) >= maxPages {
+		var lru int64
+		for k, pg := range b.pages {
+			if data == nil || pg.used < b.pages[lru].used {
+				lru, data = k, pg.data
+			}
+		}
+		delete(b.pages, lru)
+	}
+	if cap(data) < pageSize {
+		data = make([]byte, pageSize)
+	}
+	data = data[:pageSize]
+
+	n, err := b.orig.ReadAt(data, start)
+	if n == 0 && err != nil {
+		return nil, start, err
+	}
+	data = data[:n]
+	b.pages[start] = &page{data: data, used: b.tick}
+	return data, start, nil
+}
+
+// chunk returns the text of piece p from k on, or a part of it that is as much as can be had in one go, which is the rest of a page for the original.
+func (b *Buffer) chunk(p piece, k int64) ([]byte, error) {
+	if p.add {
+		return b.add[p.off+k : p.off+p.n], nil
+	}
+	data, start, err := b.page(p.off + k)
+	if err != nil {
+		return nil, err
+	}
+	if p.off+k-start >= int64(len(data)) {
+		return nil, io.ErrUnexpectedEOF // the original is shorter than it was
+	}
+	data = data[p.off+k-start:]
+	if rest := p.n - k; int64(len(data)) > rest {
+		data = data[:rest]
+	}
+	return data, nil
+}
+
+// ByteAt returns the byte at the given offset.
+func (b *Buffer) ByteAt(offset int64) (byte, error) {
+	if offset < 0 {
+		return 0, ErrOutOfRange
+	}
+	if offset >= b.length {
+		return 0, io.EOF
+	}
+	i, k := b.find(offset)
+	data, err := b.chunk(b.pieces[i], k)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// ReadAt fills p with bytes starting at offset. Like in the gap buffer, it is not an error to reach the end of the text, only to start there. Returns number of bytes and an error.
+func (b *Buffer) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, ErrOutOfRange
+	}
+	if offset >= b.length {
+		return 0, io.EOF
+	}
+	i, k := b.find(offset)
+	for ; i < len(b.pieces) && n < len(p); i, k = i+1, 0 {
+		for k < b.pieces[i].n && n < len(p) {
+			data, err := b.chunk(b.pieces[i], k)
+			if err != nil {
+				return n, err
+			}
+			c := copy(p[n:], data)
+			n += c
+			k += int64(c)
+		}
+	}
+	return n, nil
+}
+
+// each calls fn with the text in order, a piece or a page of the original at a time, for as long as fn returns true.
+func (b *Buffer) each(fn func(p []byte) bool) error {
+	for _, p := range b.pieces {
+		for k := int64(0); k < p.n; {
+			data, err := b.chunk(p, k)
+			if err != nil {
+				return err
+			}
+			if !fn(data) {
+				return nil
+			}
+			k += int64(len(data))
+		}
+	}
+	return nil
+}
+
+// pageLines returns the number of newlines in the whole page of the original at start, which is only read the first time.
+func (b *Buffer) pageLines(start int64) (int64, error) {
+	if n, ok := b.lines[start]; ok {
+		return n, nil
+	}
+	data, _, err := b.page(start)
+	if err != nil {
+		return 0, err
+	}
+	if b.lines == nil {
+		b.lines = make(map[int64]int64)
+	}
+	n := int64(bytes.Count(data, []byte{'\n'}))
+	b.lines[start] = n
+	return n, nil
+}
+
+// whole returns true if piece p covers a whole page of the original from k on.
+func whole(p piece, k int64) bool {
+	return !p.add && (p.off+k)%pageSize == 0 && p.n-k >= pageSize
+}
+
+// count returns the number of newlines in piece p before k.
+func (b *Buffer) count(p piece, k int64) (int64, error) {
+	var n int64
+	for i := int64(0); i < k; {
+		if whole(p, i) && k-i >= pageSize {
+			c, err := b.pageLines(p.off + i)
+			if err != nil {
+				return n, err
+			}
+			n += c
+			i += pageSize
+			continue
+		}
+		data, err := b.chunk(p, i)
+		if err != nil {
+			return n, err
+		}
+		if int64(len(data)) > k-i {
+			data = data[:k-i]
+		}
+		n += int64(bytes.Count(data, []byte{'\n'}))
+		i += int64(len(data))
+	}
+	return n, nil
+}
+
+// LineCount returns the number of lines, which is one more than the number of newlines. The first time, it reads all of the text.
+func (b *Buffer) LineCount() int64 {
+	n := int64(1)
+	for _, p := range b.pieces {
+		c, err := b.count(p, p.n)
+		n += c
+		if err != nil {
+			break
+		}
+	}
+	return n
+}
+
+// LineStart returns the offset of the start of line n, counting from 0. Lines before the first or after the last are taken to be the first or the last.
+func (b *Buffer) LineStart(n int64) int64 {
+	want := n
+	var off int64
+	for _, p := range b.pieces {
+		for k := int64(0); k < p.n && n > 0; {
+			if whole(p, k) {
+				c, err := b.pageLines(p.off + k)
+				if err != nil {
+					return 0
+				}
+				if c < n {
+					n -= c
+					k += pageSize
+					continue
+				}
+			}
+			data, err := b.chunk(p, k)
+			if err != nil {
+				return 0
+			}
+			for i := bytes.IndexByte(data, '\n'); i >= 0; {
+				if n--; n == 0 {
+					return off + k + int64(i) + 1
+				}
+				j := bytes.IndexByte(data[i+1:], '\n')
+				if j < 0 {
+					break
+				}
+				i += 1 + j
+			}
+			k += int64(len(data))
+		}
+		off += p.n
+	}
+	if n > 0 && want > n {
+		return b.LineStart(want - n) // the last line
+	}
+	return 0
+}
+
+// LineOf returns the line that offset is on, counting from 0, which is the number of newlines before it.
+func (b *Buffer) LineOf(offset int64) int64 {
+	var line int64
+	for _, p := range b.pieces {
+		if offset <= 0 {
+			break
+		}
+		k := p.n
+		if k > offset {
+			k = offset
+		}
+		c, err := b.count(p, k)
+		line += c
+		if err != nil {
+			break
+		}
+		offset -= k
+	}
+	return line
+}
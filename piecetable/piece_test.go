@@ -0,0 +1,248 @@
+package piecetable_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prodhe/poe/piecetable"
+)
+
+// reader is an original that counts how many times it is read.
+type reader struct {
+	*strings.Reader
+	reads  int
+	closed bool
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.Reader.ReadAt(p, off)
+}
+
+func (r *reader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func newBuffer(s string) (*piecetable.Buffer, *reader) {
+	r := &reader{Reader: strings.NewReader(s)}
+	return piecetable.New(r, int64(len(s))), r
+}
+
+func TestEdits(t *testing.T) {
+	var tt = []struct {
+		name string
+		edit func(b *piecetable.Buffer)
+		want string
+	}{
+		{"nothing", func(b *piecetable.Buffer) {}, "one\ntwo\nthree"},
+		{"insert start", func(b *piecetable.Buffer) {
+			b.Write([]byte("zero\n"))
+		}, "zero\none\ntwo\nthree"},
+		{"insert middle", func(b *piecetable.Buffer) {
+			b.Seek(4, io.SeekStart)
+			b.Write([]byte("1\n"))
+			b.Write([]byte("2\n"))
+		}, "one\n1\n2\ntwo\nthree"},
+		{"insert end", func(b *piecetable.Buffer) {
+			b.Seek(0, io.SeekEnd)
+			b.Write([]byte("\nfour"))
+		}, "one\ntwo\nthree\nfour"},
+		{"delete across pieces", func(b *piecetable.Buffer) {
+			b.Seek(4, io.SeekStart)
+			b.Write([]byte("2\n"))
+			b.DeleteRange(2, 6)
+		}, "ono\nthree"},
+		{"delete all", func(b *piecetable.Buffer) {
+			b.DeleteRange(0, b.Len())
+		}, ""},
+		{"replace", func(b *piecetable.Buffer) {
+			b.DeleteRange(4, 3)
+			b.Write([]byte("TWO"))
+			b.DeleteRange(0, 1)
+			b.Write([]byte("O"))
+		}, "One\nTWO\nthree"},
+		{"write after delete", func(b *piecetable.Buffer) {
+			b.Write([]byte("a"))
+			b.DeleteRange(1, 4)
+			b.Write([]byte("b"))
+		}, "abtwo\nthree"},
+	}
+
+	for _, tc := range tt {
+		b, _ := newBuffer("one\ntwo\nthree")
+		tc.edit(b)
+		if got := string(b.Bytes()); got != tc.want || b.Len() != int64(len(tc.want)) {
+			t.Errorf("%s: expected %q, got %q (len %d)", tc.name, tc.want, got, b.Len())
+			continue
+		}
+		for i := range tc.want {
+			if c, err := b.ByteAt(int64(i)); c != tc.want[i] || err != nil {
+				t.Errorf("%s: byte at %d: expected %q, got %q (%v)", tc.name, i, tc.want[i], c, err)
+			}
+		}
+		if _, err := b.ByteAt(b.Len()); err != io.EOF {
+			t.Errorf("%s: byte at end: expected %v, got %v", tc.name, io.EOF, err)
+		}
+		if n := b.LineCount(); n != 1+int64(strings.Count(tc.want, "\n")) {
+			t.Errorf("%s: expected %d lines, got %d", tc.name, 1+strings.Count(tc.want, "\n"), n)
+		}
+		for i := 0; i <= len(tc.want); i++ {
+			line := int64(strings.Count(tc.want[:i], "\n"))
+			start := int64(strings.LastIndexByte(tc.want[:i], '\n') + 1)
+			if got := b.LineOf(int64(i)); got != line {
+				t.Errorf("%s: offset %d: expected line %d, got %d", tc.name, i, line, got)
+			}
+			if got := b.LineStart(line); got != start {
+				t.Errorf("%s: expected line %d to start at %d, got %d", tc.name, line, start, got)
+			}
+		}
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	b, _ := newBuffer("Fusce vitae molestie tortor.")
+	b.Seek(6, io.SeekStart)
+	b.Write([]byte("very "))
+
+	var tt = []struct {
+		name      string
+		offset    int64
+		len       int
+		wantbytes []byte
+		wanterr   error
+	}{
+		{"first", 0, 5, []byte("Fusce"), nil},
+		{"across pieces", 3, 10, []byte("ce very vi"), nil},
+		{"past end", int64(len("Fusce very vitae molestie")), 10, []byte(" tortor."), nil},
+		{"out of range below", -1, 0, nil, piecetable.ErrOutOfRange},
+		{"out of range above", 9999, 0, nil, io.EOF},
+	}
+
+	for _, tc := range tt {
+		p := make([]byte, tc.len)
+		n, err := b.ReadAt(p, tc.offset)
+		if !bytes.Equal(p[:n], tc.wantbytes) || err != tc.wanterr {
+			t.Errorf("%s: expected %q (err: %v), got %q (err: %v)", tc.name, tc.wantbytes, tc.wanterr, p[:n], err)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	text := strings.Repeat(line, 50000) // 5 MB, more pages than are kept
+	b, r := newBuffer(text)
+	if r.reads != 0 {
+		t.Fatalf("expected nothing to be read when opening, got %d reads", r.reads)
+	}
+
+	// only the pages looked at are read, and only once
+	for i := 0; i < 3; i++ {
+		b.ByteAt(b.Len() - 1)
+		b.ByteAt(0)
+	}
+	if r.reads != 2 {
+		t.Errorf("expected 2 pages read, got %d", r.reads)
+	}
+
+	// the first lookup of a line reads no further than the page it is in
+	fresh, fr := newBuffer(text)
+	if q := fresh.LineStart(5); q != 500 || fr.reads != 1 {
+		t.Errorf("expected line 5 at %d after 1 read, got %d after %d reads", 500, q, fr.reads)
+	}
+	if q := fresh.LineStart(30000); q != 3000000 || fr.reads > 3000000/(64<<10)+1 {
+		t.Errorf("expected line 30000 at %d after at most %d reads, got %d after %d reads", 3000000, 3000000/(64<<10)+1, q, fr.reads)
+	}
+
+	// reading everything lets go of the pages used least recently
+	if got := string(b.Bytes()); got != text {
+		t.Fatalf("expected %d bytes, got %d", len(text), len(got))
+	}
+	reads := r.reads
+	b.ByteAt(b.Len() - 1)
+	if r.reads != reads {
+		t.Errorf("expected the last page to be kept")
+	}
+	b.ByteAt(0)
+	if r.reads != reads+1 {
+		t.Errorf("expected the first page to be read again")
+	}
+
+	if n := b.LineCount(); n != 50001 {
+		t.Errorf("expected %d lines, got %d", 50001, n)
+	}
+	if q := b.LineStart(30000); q != 3000000 {
+		t.Errorf("expected line 30000 at %d, got %d", 3000000, q)
+	}
+
+	// the newlines of each page are counted once, so looking up lines only reads the pages they are in
+	reads = r.reads
+	for _, l := range []int64{10, 30000, 49999} {
+		if q := b.LineStart(l); q != l*100 {
+			t.Errorf("expected line %d at %d, got %d", l, l*100, q)
+		}
+		if got := b.LineOf(l*100 + 50); got != l {
+			t.Errorf("expected offset %d on line %d, got %d", l*100+50, l, got)
+		}
+	}
+	if r.reads > reads+3 {
+		t.Errorf("expected at most 3 pages read looking up lines, got %d", r.reads-reads)
+	}
+
+	b.Destroy()
+	if !r.closed || b.Len() != 0 {
+		t.Errorf("expected destroy to close the original and empty the buffer")
+	}
+}
+
+func TestLines(t *testing.T) {
+	var text strings.Builder
+	for i := 0; text.Len() < 300000; i++ {
+		text.WriteString(strings.Repeat("y", i%150))
+		text.WriteByte('\n')
+	}
+	want := text.String()
+	b, _ := newBuffer(want)
+
+	// edits inside pages and across them
+	edits := []struct {
+		off, del int64
+		ins      string
+	}{
+		{70000, 0, "a\nb\n"},
+		{131000, 2000, ""},
+		{65536, 1, "\n\n"},
+		{0, 0, "\n"},
+		{200000, 70000, "c"},
+	}
+	for _, e := range edits {
+		b.DeleteRange(e.off, e.del)
+		b.Seek(e.off, io.SeekStart)
+		b.Write([]byte(e.ins))
+		want = want[:e.off] + e.ins + want[e.off+e.del:]
+	}
+	if got := string(b.Bytes()); got != want {
+		t.Fatalf("expected %d bytes, got %d", len(want), len(got))
+	}
+
+	lines := int64(strings.Count(want, "\n"))
+	if n := b.LineCount(); n != lines+1 {
+		t.Errorf("expected %d lines, got %d", lines+1, n)
+	}
+	for i := 0; i <= len(want); i += 997 {
+		line := int64(strings.Count(want[:i], "\n"))
+		start := int64(strings.LastIndexByte(want[:i], '\n') + 1)
+		if got := b.LineOf(int64(i)); got != line {
+			t.Errorf("offset %d: expected line %d, got %d", i, line, got)
+		}
+		if got := b.LineStart(line); got != start {
+			t.Errorf("expected line %d to start at %d, got %d", line, start, got)
+		}
+	}
+	last := int64(strings.LastIndexByte(want, '\n') + 1)
+	if got := b.LineStart(lines + 10); got != last {
+		t.Errorf("expected line past the end to start at %d, got %d", last, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/prodhe/poe/editor"
@@ -14,6 +15,13 @@ const poeVersion = "0.0.0"
 
 func main() {
 	version := flag.Bool("v", false, "prints current version of poe")
+	undodir := flag.String("undodir", "", "keeps undo history of files in `dir` between sessions")
+	backup := flag.String("backup", editor.BackupNone, "backs up files when saving them, with the `policy` simple (file~) or numbered (file.~1~)")
+	backupdir := flag.String("backupdir", "", "keeps backups in `dir` instead of next to the files")
+	backups := flag.Int("backups", 0, "keeps the `n` newest numbered backups of each file, or all if 0")
+	swapdir := flag.String("swapdir", defaultSwapDir(), "keeps recovery files of unsaved changes in `dir`, or none if empty")
+	enc := flag.String("enc", "", "reads files in `encoding` instead of guessing it: utf-8, utf-16le, utf-16be, latin1 or windows-1252")
+	large := flag.Int64("large", 64, "reads files of at least `mib` MiB from disk as needed instead of into memory, or all files into memory if 0")
 	// cli := flag.Bool("c", false, "run in command line")
 
 	flag.Parse()
@@ -23,8 +31,31 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch *backup {
+	case editor.BackupNone, editor.BackupSimple, editor.BackupNumbered:
+	default:
+		fmt.Fprintf(os.Stderr, "poe: unknown backup policy: %s\n", *backup)
+		os.Exit(2)
+	}
+
+	if *enc != "" {
+		var err error
+		if *enc, err = editor.LookupEncoding(*enc); err != nil {
+			fmt.Fprintf(os.Stderr, "poe: %s\n", err)
+			os.Exit(2)
+		}
+	}
+
 	// new editor with loaded files
 	e := editor.New()
+	settings := e.Settings()
+	settings.UndoDir = *undodir
+	settings.Backup = *backup
+	settings.BackupDir = *backupdir
+	settings.Backups = *backups
+	settings.SwapDir = *swapdir
+	settings.Encoding = *enc
+	settings.LargeFile = *large << 20
 	e.LoadBuffers(flag.Args())
 
 	// load client user interface
@@ -51,3 +82,12 @@ func main() {
 	// This will loop and listen on chosen UI.
 	cui.Listen()
 }
+
+// defaultSwapDir returns the directory for recovery files in the cache directory of the user.
+func defaultSwapDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "poe", "swap")
+}
@@ -0,0 +1,219 @@
+package uitcell
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	tcell "github.com/gdamore/tcell/v2"
+)
+
+// hexOffsetWidth is the width of the offset column of the hex view, including the space after it.
+const hexOffsetWidth = 10
+
+// hexRow returns the number of bytes shown on each row of the hex view, which is 16 if there is room for it.
+func (v *View) hexRow() int {
+	n := 16
+	for n > 1 && v.hexWidth(n) > v.w {
+		n /= 2
+	}
+	return n
+}
+
+// hexWidth returns the width of a row of n bytes in the hex view: the offset, the bytes in hex with an extra space after the eighth, and the bytes as text.
+func (v *View) hexWidth(n int) int {
+	w := hexOffsetWidth + 3*n + 1 + n
+	if n > 8 {
+		w++
+	}
+	return w
+}
+
+// hexColumn returns the column of byte i of a row of n bytes in the hex part of the hex view, relative to the view.
+func hexColumn(i, n int) int {
+	x := hexOffsetWidth + 3*i
+	if n > 8 && i >= 8 {
+		x++
+	}
+	return x
+}
+
+// drawHex draws the buffer as rows of offset, bytes in hex and bytes as text, like hexdump -C.
+func (v *View) drawHex() {
+	n := v.hexRow()
+	v.scrollpos -= v.scrollpos % int64(n)
+	q0, q1 := v.text.Dot()
+	length := v.text.Len()
+	ascii := hexColumn(n, n) + 1
+
+	v.opos = v.scrollpos
+	cursorShown := false
+	for y := v.y; y < v.y+v.h; y++ {
+		for x := v.x; x <= v.x+v.w; x++ {
+			screen.SetContent(x, y, ' ', nil, v.style)
+		}
+		row := v.scrollpos + int64((y-v.y)*n)
+		if row > length {
+			continue
+		}
+		for i, c := range fmt.Sprintf("%08x", row) {
+			screen.SetContent(v.x+i, y, c, nil, v.style)
+		}
+
+		for i := 0; i < n; i++ {
+			off := row + int64(i)
+			if off == q0 && q0 == q1 && v.focused {
+				x := v.x + hexColumn(i, n)
+				if v.nibble && v.nibblepos == off {
+					x++
+				}
+				screen.ShowCursor(x, y)
+				cursorShown = true
+			}
+			c, err := v.text.ByteAt(off)
+			if err != nil {
+				break
+			}
+			v.opos = off
+			style := v.style
+			if off >= q0 && off < q1 {
+				style = v.hilightStyle
+			}
+			h := fmt.Sprintf("%02x", c)
+			x := v.x + hexColumn(i, n)
+			screen.SetContent(x, y, rune(h[0]), nil, style)
+			screen.SetContent(x+1, y, rune(h[1]), nil, style)
+			if off+1 < q1 && i+1 < n {
+				screen.SetContent(x+2, y, ' ', nil, style)
+			}
+			r := rune(c)
+			if c < 0x20 || c > 0x7e {
+				r = '.'
+			}
+			screen.SetContent(v.x+ascii+i, y, r, nil, style)
+		}
+	}
+
+	if v.focused && !cursorShown {
+		screen.HideCursor()
+	}
+}
+
+// hexXYToOffset translates mouse coordinates to the byte under them in the hex view, in either the hex or the text part of it.
+func (v *View) hexXYToOffset(x, y int) int64 {
+	n := v.hexRow()
+	x -= v.x
+	ascii := hexColumn(n, n) + 1
+
+	var i int
+	switch {
+	case x >= ascii:
+		i = x - ascii
+	case x > hexOffsetWidth:
+		x -= hexOffsetWidth
+		if n > 8 && x >= 3*8 {
+			x--
+		}
+		i = x / 3
+	}
+	if i >= n {
+		i = n - 1
+	}
+
+	offset := v.scrollpos + int64((y-v.y)*n+i)
+	if offset > v.text.Len() {
+		offset = v.text.Len()
+	}
+	return offset
+}
+
+// hexScroll moves the visible part of the hex view n rows. Negative means upwards.
+func (v *View) hexScroll(n int) {
+	row := int64(v.hexRow())
+	v.scrollpos += int64(n) * row
+	if v.scrollpos > v.text.Len() {
+		v.scrollpos = v.text.Len()
+	}
+	if v.scrollpos < 0 {
+		v.scrollpos = 0
+	}
+	v.scrollpos -= v.scrollpos % row
+}
+
+// hexScrollTo scrolls the hex view to the row of offset, with a third of a page before it for context.
+func (v *View) hexScrollTo(offset int64) {
+	v.scrollpos = offset
+	v.hexScroll(-(v.h / 3))
+}
+
+// hexKey handles a key in the hex view and returns true if it did. Hex digits change the byte at the cursor, first its high and then its low half, and a byte is added at the end of the buffer. Keys that would insert text are ignored.
+func (v *View) hexKey(ev *tcell.EventKey) bool {
+	q0, q1 := v.text.Dot()
+	switch ev.Key() {
+	case tcell.KeyRune:
+		var d byte
+		switch r := ev.Rune(); {
+		case r >= '0' && r <= '9':
+			d = byte(r - '0')
+		case r >= 'a' && r <= 'f':
+			d = byte(r-'a') + 10
+		case r >= 'A' && r <= 'F':
+			d = byte(r-'A') + 10
+		default:
+			return true
+		}
+		v.hexDigit(d)
+	case tcell.KeyRight:
+		n := 1
+		if !v.text.Binary() {
+			n = utf8.RuneLen(v.Rune()) // the dot stays at runes in text
+		}
+		if n < 1 {
+			n = 1
+		}
+		v.SetCursor(q1+int64(n), io.SeekStart)
+	case tcell.KeyLeft:
+		v.SetCursor(-1, io.SeekCurrent)
+	case tcell.KeyCtrlA: // row start
+		v.SetCursor(q0-q0%int64(v.hexRow()), io.SeekStart)
+	case tcell.KeyCtrlE: // row end
+		n := int64(v.hexRow())
+		v.SetCursor(q0-q0%n+n-1, io.SeekStart)
+	case tcell.KeyBackspace2, tcell.KeyCtrlH:
+		if q0 == q1 {
+			if q0 == 0 {
+				return true
+			}
+			q0--
+		}
+		v.text.Replace(q0, q1, nil)
+		v.SetCursor(q0, io.SeekStart)
+	case tcell.KeyCR, tcell.KeyLF, tcell.KeyTab, tcell.KeyCtrlU, tcell.KeyCtrlW: // text only
+	default:
+		return false
+	}
+	if ev.Key() != tcell.KeyRune {
+		v.nibble = false
+	}
+	return true
+}
+
+// hexDigit types the hex digit d at the cursor of the hex view. It sets the high half of the byte at the cursor and then, on the next digit, the low half before moving on. A selection is replaced by a new byte, and so is nothing at the end of the buffer.
+func (v *View) hexDigit(d byte) {
+	q0, q1 := v.text.Dot()
+	if v.nibble && v.nibblepos == q0 && q0 == q1 {
+		c, _ := v.text.ByteAt(q0)
+		v.text.Replace(q0, q0+1, []byte{c&0xf0 | d})
+		v.nibble = false
+		v.SetCursor(q0+1, io.SeekStart)
+		return
+	}
+	c := d << 4
+	if old, err := v.text.ByteAt(q0); err == nil && q0 == q1 {
+		c |= old & 0x0f
+		q1 = q0 + 1
+	}
+	v.text.Replace(q0, q1, []byte{c})
+	v.text.SetDot(q0, q0)
+	v.nibble, v.nibblepos = true, q0
+}
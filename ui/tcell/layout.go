@@ -2,6 +2,7 @@ package uitcell
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/prodhe/poe/editor"
 )
@@ -14,6 +15,7 @@ type Workspace struct {
 	x, y, w, h int
 	tagline    *View
 	cols       []*Column
+	jobs       string // running jobs as last shown in tagline
 }
 
 type Column struct {
@@ -61,6 +63,28 @@ func (wrk *Workspace) AddCol() *Column {
 	return newcol
 }
 
+// UpdateJobs lists the running jobs last in the tagline, replacing those listed before.
+func (wrk *Workspace) UpdateJobs() {
+	var names []string
+	for _, j := range ed.Jobs() {
+		names = append(names, j.Name())
+	}
+	jobs := strings.Join(names, " ")
+	if jobs == wrk.jobs {
+		return
+	}
+
+	text := wrk.tagline.text
+	tag := strings.TrimSuffix(text.String(), wrk.jobs)
+	if jobs != "" && !strings.HasSuffix(tag, " ") {
+		tag += " "
+	}
+	q0, q1 := text.Dot()
+	text.Replace(0, text.Len(), []byte(tag+jobs))
+	text.SetDot(q0, q1)
+	wrk.jobs = jobs
+}
+
 func (wrk *Workspace) CloseCol(c *Column) {
 	var j int
 	for _, col := range wrk.cols {
@@ -2,8 +2,11 @@ package uitcell
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	tcell "github.com/gdamore/tcell/v2"
 	"github.com/prodhe/poe/editor"
@@ -13,6 +16,9 @@ const (
 	FnMessageWin  = "+poe"
 	FnEmptyWin    = ""
 	RuneWidthZero = '?'
+
+	swapInterval = 10 * time.Second // how often recovery files of changed buffers are written
+	pollInterval = 2 * time.Second  // how often files and directories are checked for changes on disk
 )
 
 var (
@@ -22,7 +28,8 @@ var (
 	CurCol    *Column
 	CurWin    *Window
 
-	poecmds map[string]commandFunc
+	poecmds    map[string]commandFunc
+	poeargcmds map[string]argCommandFunc
 
 	quit   chan bool
 	events chan tcell.Event
@@ -30,6 +37,9 @@ var (
 
 type commandFunc func()
 
+// argCommandFunc is a command taking the rest of the input as argument. It returns any output for the message window.
+type argCommandFunc func(args string) string
+
 type Tcell struct{}
 
 func (t *Tcell) Init(e editor.Editor) error {
@@ -75,16 +85,22 @@ func (t *Tcell) Close() {
 	screen.Fini()
 }
 
+// printMsg prints to the message window of the current window's directory.
 func printMsg(format string, a ...interface{}) {
-	// get output window
-	var poename string
+	var dir string
 
 	if CurWin != nil {
-		poename = CurWin.Dir()
+		dir = CurWin.Dir()
 	} else {
-		poename = ed.WorkDir()
+		dir = ed.WorkDir()
 	}
-	poename += string(filepath.Separator) + FnMessageWin
+	printDirMsg(dir, format, a...)
+}
+
+// printDirMsg prints to the message window of the given directory, which is created if it does not exist.
+func printDirMsg(dir, format string, a ...interface{}) {
+	// get output window
+	poename := dir + string(filepath.Separator) + FnMessageWin
 	poename = filepath.Clean(poename)
 
 	poewin := FindWindow(poename)
@@ -136,7 +152,7 @@ func initWorkspace() error {
 			tabstop:      4,
 		},
 	}
-	fmt.Fprintf(workspace.tagline, "%s", "Newcol Exit ")
+	fmt.Fprintf(workspace.tagline, "%s", "Newcol Kill Exit ")
 	workspace.AddCol()
 	if ids, _ := ed.Buffers(); len(ids) == 0 {
 		workspace.AddCol()
@@ -146,26 +162,61 @@ func initWorkspace() error {
 
 func initWindows() error {
 	ids, _ := ed.Buffers()
+	var wins []*Window
 	for _, id := range ids {
 		win := NewWindow(id)
 		workspace.LastCol().AddWindow(win)
 		CurWin = win
+		wins = append(wins, win)
+	}
+	for _, win := range wins {
+		checkSwap(win)
 	}
 	return nil
 }
 
+// checkSwap tells the user if there is a recovery file left over for the file in win, and how it differs from the file on disk.
+func checkSwap(win *Window) {
+	name := win.body.text.SwapFile()
+	if name == "" {
+		return
+	}
+	diff, err := win.body.text.DiffSwap()
+	if err != nil {
+		diff = err.Error() + "\n"
+	}
+	printDirMsg(win.Dir(), "%s: unsaved changes found in %s; Recover to restore them\n%s", win.Name(), name, diff)
+}
+
 func initCommands() {
 	poecmds = map[string]commandFunc{
-		"Newcol": CmdNewcol,
-		"Delcol": CmdDelcol,
-		"New":    CmdNew,
-		"Del":    CmdDel,
-		"Get":    CmdGet,
-		"Exit":   CmdExit,
+		"Newcol":  CmdNewcol,
+		"Delcol":  CmdDelcol,
+		"New":     CmdNew,
+		"Del":     CmdDel,
+		"Get":     CmdGet,
+		"Diff":    CmdDiff,
+		"Recover": CmdRecover,
+		"Hex":     CmdHex,
+		"Ro":      CmdRo,
+		"Exit":    CmdExit,
+	}
+	poeargcmds = map[string]argCommandFunc{
+		"Edit":    CmdEdit,
+		"Put":     CmdPut,
+		"Kill":    CmdKill,
+		"Cd":      CmdCd,
+		"Branch":  CmdBranch,
+		"Earlier": CmdEarlier,
+		"Later":   CmdLater,
+		"Backup":  CmdBackup,
+		"Eol":     CmdEol,
+		"Enc":     CmdEnc,
 	}
 }
 
 func (t *Tcell) redraw() {
+	workspace.UpdateJobs()
 	workspace.Draw()
 	screen.Show()
 }
@@ -177,6 +228,11 @@ func (t *Tcell) Listen() {
 		}
 	}()
 
+	swaps := time.NewTicker(swapInterval)
+	defer swaps.Stop()
+	polls := time.NewTicker(pollInterval)
+	defer polls.Stop()
+
 outer:
 	for {
 		// draw
@@ -187,6 +243,21 @@ outer:
 		select {
 		case <-quit:
 			break outer
+		case ev := <-ed.JobEvents():
+			if out := ed.HandleJobEvent(ev); out != "" {
+				printDirMsg(ev.Job.Dir, "%s", out)
+			}
+			continue
+		case <-swaps.C:
+			if err := ed.WriteSwaps(); err != nil {
+				printMsg("%s\n", err)
+			}
+			continue
+		case <-polls.C:
+			for _, id := range ed.Poll() {
+				printDirMsg(ed.Dir(id), "%s: changed on disk; Get to reload, Put to overwrite or Diff to compare\n", ed.Buffer(id).Name())
+			}
+			continue
 		case event = <-events:
 		}
 
@@ -283,6 +354,13 @@ func Cmd(input string) string {
 		fn()
 		return ""
 	}
+	if fn, ok := poeargcmds[cmd[0]]; ok {
+		return fn(strings.TrimSpace(input[len(cmd[0]):]))
+	}
+
+	if CurWin == nil {
+		return ""
+	}
 
 	// Edit shortcuts for external commands and piping
 	switch input[0] {
@@ -293,18 +371,208 @@ func Cmd(input string) string {
 	return ed.Edit(CurWin.bufid, "!"+input)
 }
 
-func CmdOpen(fn string) {
+// CmdEdit runs args in the edit language on the current window.
+func CmdEdit(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	return ed.Edit(CurWin.bufid, args)
+}
+
+// CmdKill kills the running jobs with the name or id given in args, or all of them if args is empty.
+func CmdKill(args string) string {
+	if err := ed.Kill(args); err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdCd changes the working directory of the editor to args, which is where new windows without a file run their commands. Without args, the current one is printed.
+func CmdCd(args string) string {
+	if args == "" {
+		return ed.WorkDir() + "\n"
+	}
+	if err := ed.SetWorkDir(args); err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdBranch switches the current window to another branch of its undo history. Args is the number of branches to move, which defaults to 1 and may be negative.
+func CmdBranch(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	n := 1
+	if args != "" {
+		var err error
+		if n, err = strconv.Atoi(args); err != nil {
+			return fmt.Sprintf("bad count: %s\n", args)
+		}
+	}
+	if err := CurWin.body.text.Branch(n); err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdEarlier moves the current window back in its undo history. Args is either a number of changes, which defaults to 1, or a duration like 5m, which goes to the text as it was that long ago.
+func CmdEarlier(args string) string {
+	return travel(args, -1)
+}
+
+// CmdLater moves the current window forward in its undo history, like CmdEarlier. A duration counts from the last change to the current text.
+func CmdLater(args string) string {
+	return travel(args, 1)
+}
+
+// travel moves the current window through its undo history in the direction of sign, by the number of changes or the duration given in args.
+func travel(args string, sign int) string {
+	if CurWin == nil {
+		return ""
+	}
+	text := CurWin.body.text
+	var err error
+	if n, nerr := strconv.Atoi(args); args == "" || nerr == nil {
+		if args == "" {
+			n = 1
+		}
+		err = text.Step(sign * n)
+	} else {
+		d, derr := time.ParseDuration(args)
+		if derr != nil {
+			return fmt.Sprintf("bad count or duration: %s\n", args)
+		}
+		if sign < 0 {
+			err = text.Earlier(d)
+		} else {
+			err = text.Later(d)
+		}
+	}
+	if err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdBackup lists the backups of the file in the current window, newest first. With a number in args, that backup is opened in a new window instead, ready to be saved over the file.
+func CmdBackup(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	names, err := CurWin.body.text.Backups()
+	if err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+
+	if args == "" {
+		if len(names) == 0 {
+			return fmt.Sprintf("%s: no backups\n", CurWin.Name())
+		}
+		var out strings.Builder
+		for i, name := range names {
+			var mtime string
+			if info, err := os.Stat(name); err == nil {
+				mtime = info.ModTime().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(&out, "%d %s %s\n", i+1, mtime, name)
+		}
+		return out.String()
+	}
+
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 || n > len(names) {
+		return fmt.Sprintf("no such backup: %s\n", args)
+	}
+	id, buf := ed.NewBuffer()
+	buf.NewFile(CurWin.Name())
+	if err := buf.ReadFile(); err != nil { // read first, so that saving checks the file like in any window
+		ed.CloseBuffer(id)
+		return fmt.Sprintf("%s\n", err)
+	}
+	if err := buf.RestoreBackup(names[n-1]); err != nil {
+		ed.CloseBuffer(id)
+		return fmt.Sprintf("%s\n", err)
+	}
+	CurWin.col.AddWindow(NewWindow(id))
+	return ""
+}
+
+// CmdHex switches the current window between showing text and showing bytes in hex.
+func CmdHex() {
+	if CurWin == nil {
+		return
+	}
+	CurWin.body.hex = !CurWin.body.hex
+	CurWin.body.nibble = false
+	CurWin.body.ScrollTo(CurWin.body.Cursor())
+}
+
+// CmdRo switches the file of the current window between read-only and writable, for example to save changes to a binary file.
+func CmdRo() {
+	if CurWin == nil {
+		return
+	}
+	if err := CurWin.body.text.SetReadOnly(!CurWin.body.text.ReadOnly()); err != nil {
+		printMsg("%s\n", err)
+	}
+}
+
+// CmdEol sets the line endings of the file in the current window to lf or crlf, which takes effect when it is saved. Without argument, it prints the current line endings.
+func CmdEol(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	var crlf bool
+	switch args {
+	case "":
+		eol := "lf"
+		if CurWin.body.text.CRLF() {
+			eol = "crlf"
+		}
+		return fmt.Sprintf("%s: %s\n", CurWin.Name(), eol)
+	case "lf":
+	case "crlf":
+		crlf = true
+	default:
+		return fmt.Sprintf("unknown line ending: %s\n", args)
+	}
+	if err := CurWin.body.text.SetCRLF(crlf); err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdEnc sets the character encoding of the file in the current window, which takes effect when it is saved. Without argument, it prints the current encoding.
+func CmdEnc(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	if args == "" {
+		return fmt.Sprintf("%s: %s\n", CurWin.Name(), CurWin.body.text.Encoding())
+	}
+	if err := CurWin.body.text.SetEncoding(args); err != nil {
+		return fmt.Sprintf("%s\n", err)
+	}
+	return ""
+}
+
+// CmdOpen opens fn in a new window, unless it is already open, and selects addr in it.
+func CmdOpen(fn, addr string) {
 	screen.Clear()
 	var win *Window
 	win = FindWindow(fn)
 
 	if win != nil { //only load windows that do no already exists
+		win.Show(addr)
 		return
 	}
 
 	id, buf := ed.NewBuffer()
 	buf.NewFile(fn)
-	buf.ReadFile()
+	if err := buf.ReadFile(); err != nil {
+		printMsg("%s\n", err)
+	}
 	win = NewWindow(id)
 	var col *Column
 	if !buf.IsDir() {
@@ -319,6 +587,8 @@ func CmdOpen(fn string) {
 		col = workspace.LastCol()
 	}
 	col.AddWindow(win)
+	win.Show(addr)
+	checkSwap(win)
 }
 
 func CmdNew() {
@@ -354,14 +624,52 @@ func CmdGet() {
 	wins := AllWindows()
 	for _, win := range wins {
 		if win.tagline.focused || win.body.focused {
-			q0, q1 := win.body.text.Dot()
-			win.body.text.Destroy()
-			win.body.text.ReadFile()
-			win.body.text.SetDot(q0, q1)
+			if err := win.body.text.Reload(); err != nil {
+				printMsg("%s\n", err)
+			}
 		}
 	}
 }
 
+// CmdPut writes the current window to its file, even if the file has been changed by someone else since it was read. With a file name in args, or if the name in the tagline has been changed, the window is written to a new file of that name instead.
+func CmdPut(args string) string {
+	if CurWin == nil {
+		return ""
+	}
+	if args == "" {
+		args = CurWin.TagName()
+	}
+	CurWin.Put(args, true)
+	return ""
+}
+
+// CmdDiff shows the differences between the file on disk and the current window.
+func CmdDiff() {
+	if CurWin == nil {
+		return
+	}
+	diff, err := CurWin.body.text.DiffFile()
+	if err != nil {
+		printMsg("%s\n", err)
+		return
+	}
+	if diff == "" {
+		printMsg("%s: no differences\n", CurWin.Name())
+		return
+	}
+	printMsg("%s", diff)
+}
+
+// CmdRecover replaces the text of the current window with the unsaved changes left over from an earlier session.
+func CmdRecover() {
+	if CurWin == nil {
+		return
+	}
+	if err := CurWin.body.text.Recover(); err != nil {
+		printMsg("%s: %s\n", CurWin.Name(), err)
+	}
+}
+
 func CmdExit() {
 	exit := true
 	wins := AllWindows()
@@ -371,6 +679,9 @@ func CmdExit() {
 		}
 	}
 	if exit {
+		for _, win := range wins {
+			ed.CloseBuffer(win.bufid)
+		}
 		quit <- true
 	}
 }
@@ -1,9 +1,12 @@
 package uitcell
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -24,20 +27,25 @@ const (
 
 const ClickThreshold = 500 // in milliseconds to count as double click
 
+var lineColRe = regexp.MustCompile(`^([0-9]+):([0-9]+)$`)
+
 type View struct {
 	x, y, w, h   int
 	style        tcell.Style
 	cursorStyle  tcell.Style
 	hilightStyle tcell.Style
 	text         *editor.Buffer
-	scrollpos    int // bytes to skip when drawing content
-	opos         int // overflow offset
+	scrollpos    int64 // bytes to skip when drawing content
+	opos         int64 // overflow offset
 	tabstop      int
 	focused      bool
 	what         int
 	mclicktime   time.Time // last mouse click in time
-	mclickpos    int       // byte offset accounting for runes
+	mclickpos    int64     // byte offset accounting for runes
 	mpressed     bool
+	hex          bool // show bytes in hex instead of text
+	nibble       bool // the high half of the byte at nibblepos was just typed in hex
+	nibblepos    int64
 }
 
 func (v *View) Write(p []byte) (int, error) {
@@ -75,7 +83,7 @@ func (v *View) Rune() rune {
 }
 
 // Cursor returns start of dot.
-func (v *View) Cursor() int {
+func (v *View) Cursor() int64 {
 	q0, _ := v.text.Dot()
 	return q0
 }
@@ -85,7 +93,7 @@ func (v *View) Dirty() bool {
 	return v.text.Dirty()
 }
 
-func (v *View) SetCursor(pos, whence int) {
+func (v *View) SetCursor(pos int64, whence int) {
 	v.text.SeekDot(pos, whence)
 
 	// scroll to cursor if out of screen
@@ -97,7 +105,11 @@ func (v *View) SetCursor(pos, whence int) {
 }
 
 // XYToOffset translates mouse coordinates in a 2D terminal to the correct byte offset in buffer, accounting for rune length, width and tabstops.
-func (v *View) XYToOffset(x, y int) int {
+func (v *View) XYToOffset(x, y int) int64 {
+	if v.hex {
+		return v.hexXYToOffset(x, y)
+	}
+
 	offset := v.scrollpos
 
 	// vertical (number of visual lines)
@@ -120,7 +132,7 @@ func (v *View) XYToOffset(x, y int) int {
 		for r != '\n' && xw <= v.x+v.w {
 			var n int
 			r, n, _ = v.text.ReadRuneAt(offset)
-			offset += n
+			offset += int64(n)
 			rw := RuneWidth(r)
 			if r == '\t' {
 				rw = v.tabstop - (xw-v.x)%v.tabstop
@@ -147,7 +159,7 @@ func (v *View) XYToOffset(x, y int) int {
 		if r == '\n' {
 			break
 		}
-		offset += n
+		offset += int64(n)
 		rw := RuneWidth(r)
 		if r == '\t' {
 			rw = v.tabstop - (xw-v.x)%v.tabstop
@@ -163,7 +175,12 @@ func (v *View) XYToOffset(x, y int) int {
 
 // Scroll will move the visible part of the buffer in number of lines, accounting for soft wraps and tabstops. Negative means upwards.
 func (v *View) Scroll(n int) {
-	offset := 0
+	if v.hex {
+		v.hexScroll(n)
+		return
+	}
+
+	var offset int64
 
 	xw := v.x // for tabstop count and soft wrap
 	switch {
@@ -177,7 +194,7 @@ func (v *View) Scroll(n int) {
 				}
 				return
 			}
-			offset += size
+			offset += int64(size)
 
 			rw := RuneWidth(r)
 			if r == '\t' {
@@ -226,7 +243,12 @@ func (v *View) Scroll(n int) {
 }
 
 // ScrollTo will scroll to an absolute byte offset in the buffer and backwards to the nearest previous newline.
-func (v *View) ScrollTo(offset int) {
+func (v *View) ScrollTo(offset int64) {
+	if v.hex {
+		v.hexScrollTo(offset)
+		return
+	}
+
 	offset -= v.text.PrevDelim('\n', offset)
 	if offset > 0 {
 		offset += 1
@@ -236,6 +258,11 @@ func (v *View) ScrollTo(offset int) {
 }
 
 func (b *View) Draw() {
+	if b.hex {
+		b.drawHex()
+		return
+	}
+
 	// screen.HideCursor()
 
 	x, y := b.x, b.y
@@ -281,8 +308,8 @@ func (b *View) Draw() {
 				printMsg("rune [%d]: %s\n", i, err)
 				break
 			}
-			b.opos += n // increment last visible char/overflow
-			i += n      // jump past bytes for next run
+			b.opos += int64(n) // increment last visible char/overflow
+			i += int64(n)      // jump past bytes for next run
 
 			// color the entire line if we are in selection
 			fillstyle := b.style
@@ -429,6 +456,10 @@ func (v *View) HandleEvent(ev tcell.Event) {
 			printMsg("%#v", btn)
 		}
 	case *tcell.EventKey:
+		if v.hex && v.hexKey(ev) {
+			return
+		}
+
 		key := ev.Key()
 		switch key {
 		case tcell.KeyCR: // use unix style 0x0A (\n) for new lines
@@ -436,7 +467,7 @@ func (v *View) HandleEvent(ev tcell.Event) {
 		case tcell.KeyRight:
 			_, q1 := v.text.Dot()
 			v.SetCursor(q1, io.SeekStart)
-			v.SetCursor(utf8.RuneLen(v.Rune()), io.SeekCurrent)
+			v.SetCursor(int64(utf8.RuneLen(v.Rune())), io.SeekCurrent)
 			return
 		case tcell.KeyLeft:
 			v.SetCursor(-1, io.SeekCurrent)
@@ -507,9 +538,11 @@ func (v *View) HandleEvent(ev tcell.Event) {
 			return
 		case tcell.KeyCtrlG: // file info/statistics
 			sw, sh := screen.Size()
-			printMsg("0x%.4x %q len %d\nbasedir: %s\nwindir: %s\nname: %s\nw: %d h: %d sw: %d sh: %d\n",
+			line, col := v.text.OffsetToLineCol(v.Cursor())
+			printMsg("0x%.4x %q len %d\nline: %d col: %d lines: %d\nbasedir: %s\nwindir: %s\nname: %s\nw: %d h: %d sw: %d sh: %d\n",
 				v.Rune(), v.Rune(),
 				v.text.Len(),
+				line+1, col+1, v.text.LineCount(),
 				ed.WorkDir(), CurWin.Dir(), CurWin.Name(),
 				CurWin.w, CurWin.h, sh, sw)
 			return
@@ -542,6 +575,9 @@ func (v *View) HandleEvent(ev tcell.Event) {
 		}
 
 		// insert if no early return
+		if v.hex {
+			return
+		}
 		if key == tcell.KeyRune {
 			v.Write([]byte(string(ev.Rune())))
 		} else {
@@ -576,6 +612,7 @@ func ButtonSecondary(v *View, mx, my int) {
 	if fn == "" { // if it is still blank, abort
 		return
 	}
+	fn, addr := splitAddr(fn)
 	if fn != "" && fn[0] != filepath.Separator {
 		if CurWin != nil {
 			fn = CurWin.Dir() + string(filepath.Separator) + fn
@@ -596,10 +633,27 @@ func ButtonSecondary(v *View, mx, my int) {
 		return
 	}
 
-	CmdOpen(fn)
+	CmdOpen(fn, addr)
 	return
 }
 
+// splitAddr splits an open target like poe.go:25 or poe.go:/func main/ into a file name and an address. The line:column form of compilers, like poe.go:25:3:, is turned into an address of that column.
+func splitAddr(s string) (fn, addr string) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return s, ""
+	}
+	fn, addr = s[:i], strings.TrimSuffix(s[i+1:], ":")
+	if m := lineColRe.FindStringSubmatch(addr); m != nil {
+		col, _ := strconv.Atoi(m[2])
+		if col > 0 {
+			col--
+		}
+		addr = fmt.Sprintf("%s-#0+#%d", m[1], col)
+	}
+	return fn, addr
+}
+
 func ButtonMiddle(v *View, mx, my int) {
 	pos := v.XYToOffset(mx, my)
 	// if we clicked outside a current selection, run that one
@@ -3,6 +3,7 @@ package uitcell
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	tcell "github.com/gdamore/tcell/v2"
 	"github.com/prodhe/poe/editor"
@@ -42,13 +43,11 @@ func NewWindow(id int64) *Window {
 		},
 	}
 
-	tagname := win.TagName()
-	sep := string(filepath.Separator)
-	if win.body.text.IsDir() && tagname != sep {
-		tagname += sep
-	}
-	fmt.Fprintf(win.tagline, "%s Del Get ",
-		tagname,
+	win.body.text.Mark(&win.body.scrollpos)
+	win.body.hex = win.body.text.Binary()
+
+	fmt.Fprintf(win.tagline, "%s Del Get Put ",
+		win.tagName(win.Name()),
 	)
 
 	return win
@@ -94,16 +93,67 @@ func (win *Window) Name() string {
 	return win.body.text.Name()
 }
 
+// TagName returns the file name written first in the tagline, as an absolute path. It is the same as Name, unless the user has edited it, in which case the next Put writes to the new name.
 func (win *Window) TagName() string {
-	return win.body.text.Name()
+	name := win.tagline.text.String()
+	name = name[:tagNameEnd(name)]
+	if name == "" {
+		return ""
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(win.Dir(), name)
+	}
+	return filepath.Clean(name)
+}
+
+// tagName returns name as it is written in the tagline, where directories end with a separator.
+func (win *Window) tagName(name string) string {
+	sep := string(filepath.Separator)
+	if win.body.text.IsDir() && name != sep {
+		name += sep
+	}
+	return name
+}
+
+// tagNameEnd returns where the file name ends in tag, which is before the commands that follow it. As the name may have spaces, that is at the space before Del, or else at the first space or tab.
+func tagNameEnd(tag string) int {
+	if i := strings.Index(tag, " Del "); i >= 0 {
+		return i
+	}
+	if i := strings.IndexAny(tag, " \t"); i >= 0 {
+		return i
+	}
+	return len(tag)
+}
+
+// SetTagName replaces the file name in the tagline.
+func (win *Window) SetTagName(name string) {
+	text := win.tagline.text
+	n := int64(tagNameEnd(text.String()))
+	name = win.tagName(name)
+	q0, q1 := text.Dot()
+	text.Replace(0, n, []byte(name))
+	d := int64(len(name)) - n
+	text.SetDot(q0+d, q1+d)
 }
 
+// Dir returns the directory of the window, which is where its commands run and relative names are opened from.
 func (win *Window) Dir() string {
-	d := win.body.text.WorkDir()
-	if d == "." {
-		return ed.WorkDir() // base path for program
+	return ed.Dir(win.bufid)
+}
+
+// Show selects the text at the given address and scrolls it into view. An empty address does nothing.
+func (win *Window) Show(addr string) {
+	if addr == "" {
+		return
 	}
-	return d
+	q0, q1, err := win.body.text.Address(addr)
+	if err != nil {
+		printMsg("%s: %s\n", addr, err)
+		return
+	}
+	win.body.text.SetDot(q0, q1)
+	win.body.ScrollTo(q0)
 }
 
 func (win *Window) Flags() [2]rune {
@@ -117,6 +167,39 @@ func (win *Window) Flags() [2]rune {
 	return flags
 }
 
+// Status returns a short description of the state of the file in the window, like it being new, read-only or stale since it changed on disk while there were unsaved changes. It is empty if there is nothing to tell.
+func (win *Window) Status() string {
+	var status []string
+	if win.body.text.IsNew() {
+		status = append(status, "new")
+	}
+	if win.body.text.Binary() {
+		status = append(status, "bin")
+	}
+	if win.body.text.Paged() {
+		status = append(status, "paged")
+	}
+	if win.body.text.ReadOnly() {
+		status = append(status, "ro")
+	}
+	if win.body.text.Stale() {
+		status = append(status, "stale")
+	}
+	if enc := win.body.text.Encoding(); enc != editor.EncodingUTF8 {
+		status = append(status, enc)
+	}
+	if win.body.text.CRLF() {
+		status = append(status, "crlf")
+	}
+	if win.body.text.BOM() {
+		status = append(status, "bom")
+	}
+	if len(status) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(status, " ") + "]"
+}
+
 func (win *Window) HandleEvent(ev tcell.Event) {
 	switch ev := ev.(type) {
 	case *tcell.EventMouse:
@@ -132,10 +215,7 @@ func (win *Window) HandleEvent(ev tcell.Event) {
 	case *tcell.EventKey:
 		switch ev.Key() {
 		case tcell.KeyCtrlS: // save
-			_, err := win.body.text.SaveFile()
-			if err != nil {
-				printMsg("%s\n", err)
-			}
+			win.Save(false)
 			return
 		}
 	}
@@ -148,12 +228,63 @@ func (win *Window) HandleEvent(ev tcell.Event) {
 	}
 }
 
+// Save writes the body to the file named in the tagline. Unless force is set, a file that has been changed on disk is left alone, and the user is told how to go on.
+func (win *Window) Save(force bool) {
+	win.Put(win.TagName(), force)
+}
+
+// Put writes the body to the file name, which is relative to the directory of the window. If it is not the file of the window, it is created and must not already exist, and the window takes its name. Otherwise, unless force is set, a file that has been changed on disk is left alone.
+func (win *Window) Put(name string, force bool) {
+	if name != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(win.Dir(), name)
+	}
+	if name != "" && filepath.Clean(name) != win.Name() {
+		name = filepath.Clean(name)
+		if _, err := win.body.text.SaveAs(name); err != nil {
+			printMsg("%s\n", err)
+			return
+		}
+		win.SetTagName(name)
+		return
+	}
+
+	var err error
+	if force {
+		_, err = win.body.text.OverwriteFile()
+	} else {
+		_, err = win.body.text.SaveFile()
+	}
+	if err == editor.ErrModified {
+		printMsg("%s: %s; Put to overwrite or Diff to compare\n", win.Name(), err)
+		return
+	}
+	if err != nil {
+		printMsg("%s\n", err)
+	}
+}
+
 func (win *Window) Draw() {
 	flags := win.Flags()
 	screen.SetContent(win.x, win.y, flags[0], nil, win.tagline.style)
 	screen.SetContent(win.x+1, win.y, flags[1], nil, win.tagline.style)
 	screen.SetContent(win.x+2, win.y, ' ', nil, win.tagline.style)
 
+	// Status, right aligned after the tagline if there is room, which makes the tagline narrower so that none of it is hidden
+	status := win.Status()
+	if status != "" && len(status)+1 >= win.w-3 {
+		status = ""
+	}
+	if status != "" {
+		win.tagline.Resize(win.x+3, win.y, win.w-3-len(status)-1, 1)
+		x := win.x + win.w - len(status)
+		for i, r := range status {
+			screen.SetContent(x+i, win.y, r, nil, win.tagline.style)
+		}
+		screen.SetContent(x-1, win.y, ' ', nil, win.tagline.style)
+	} else {
+		win.tagline.Resize(win.x+3, win.y, win.w-3, 1)
+	}
+
 	// Tagline
 	win.tagline.Draw()
 
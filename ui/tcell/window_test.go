@@ -0,0 +1,24 @@
+package uitcell
+
+import "testing"
+
+func TestTagNameEnd(t *testing.T) {
+	var tt = []struct {
+		tag  string
+		want string
+	}{
+		{"/x/poe.go Del Get Put ", "/x/poe.go"},
+		{"/x/my file.txt Del Get Put ", "/x/my file.txt"},
+		{"/x/dir/ Del Get Put Look", "/x/dir/"},
+		{" Del Get Put ", ""},
+		{"/x/new.go Get", "/x/new.go"},
+		{"/x/new.go\tGet", "/x/new.go"},
+		{"/x/new.go", "/x/new.go"},
+	}
+
+	for _, tc := range tt {
+		if got := tc.tag[:tagNameEnd(tc.tag)]; got != tc.want {
+			t.Errorf("%q: expected name %q, got %q", tc.tag, tc.want, got)
+		}
+	}
+}